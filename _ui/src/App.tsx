@@ -28,6 +28,7 @@ function App() {
             query: message.query,
             data_csv: message.data_csv,
             err: message.err,
+            prompt: message.prompt,
           },
         ]
       )
@@ -3,11 +3,35 @@ import { useState } from 'react';
 import SyntaxHighlighter from 'react-syntax-highlighter';
 import { docco } from 'react-syntax-highlighter/dist/esm/styles/hljs';
 
+type promptUsage = {
+    tokens: number,
+    limit: number,
+    summarized?: string[],
+    dropped?: string[],
+}
+
 type message = {
     question: string,
     query: string,
     data_csv: string,
     err: string,
+    prompt?: promptUsage,
+}
+
+// promptNote describes any earlier questions left out of the prompt
+function promptNote(prompt?: promptUsage) {
+    if (!prompt) {
+        return <></>;
+    }
+    const summarized = prompt.summarized?.length ?? 0;
+    const dropped = prompt.dropped?.length ?? 0;
+    if (summarized === 0 && dropped === 0) {
+        return <></>;
+    }
+    return <div className="uk-text-meta uk-margin-top">
+        To fit the model's context window, {summarized} earlier question(s) were summarized and {dropped} left out.
+        The prompt used {prompt.tokens} of {prompt.limit} tokens.
+    </div>;
 }
 
 function Conversation({conversationid, messages}: {conversationid: string, messages: message[]}) {
@@ -38,6 +62,7 @@ function Conversation({conversationid, messages}: {conversationid: string, messa
             </div>
             <div className="uk-card uk-card-default uk-card-body uk-margin">
             {content}
+            {promptNote(message.prompt)}
             </div>
         </div>
     });
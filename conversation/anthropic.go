@@ -0,0 +1,174 @@
+package conversation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultAnthropicBaseURL = "https://api.anthropic.com"
+	anthropicVersion        = "2023-06-01"
+	// defaultAnthropicMaxTokens is used where a request does not limit the
+	// length of responses, as the Anthropic API requires a limit
+	defaultAnthropicMaxTokens = 1024
+)
+
+// NewAnthropicProvider returns a provider using Anthropic's Messages API.
+// If baseURL is empty, the public API is used.
+func NewAnthropicProvider(apiKey, baseURL, model string) Provider {
+	if baseURL == "" {
+		baseURL = defaultAnthropicBaseURL
+	}
+	return &anthropicProvider{
+		client:  http.DefaultClient,
+		apiKey:  apiKey,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		model:   model,
+	}
+}
+
+type anthropicProvider struct {
+	client  *http.Client
+	apiKey  string
+	baseURL string
+	model   string
+}
+
+func (p *anthropicProvider) Model() string {
+	return p.model
+}
+
+func (p *anthropicProvider) MaxTemperature() float32 {
+	return 1
+}
+
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	MaxTokens   int                `json:"max_tokens"`
+	Temperature *float32           `json:"temperature,omitempty"`
+}
+
+type anthropicResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Complete requests each response separately, as the API returns a single
+// response per request
+func (p *anthropicProvider) Complete(ctx context.Context, req CompletionRequest) ([]string, error) {
+	body := anthropicRequest{
+		Model:       p.model,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+	}
+	if req.Model != "" {
+		body.Model = req.Model
+	}
+	if body.MaxTokens == 0 {
+		body.MaxTokens = defaultAnthropicMaxTokens
+	}
+	body.System, body.Messages = anthropicMessages(req.Messages)
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("encoding request: %w", err)
+	}
+
+	n := req.N
+	if n < 1 {
+		n = 1
+	}
+	var responses []string
+	for i := 0; i < n; i++ {
+		response, err := p.complete(ctx, encoded)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, response)
+	}
+	return responses, nil
+}
+
+func (p *anthropicProvider) complete(ctx context.Context, body []byte) (string, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-Api-Key", p.apiKey)
+	httpReq.Header.Set("Anthropic-Version", anthropicVersion)
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("Anthropic error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var response anthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("Anthropic error: decoding response with status %v: %w", resp.Status, err)
+	}
+	if response.Error != nil {
+		return "", fmt.Errorf("Anthropic error: %v: %v", response.Error.Type, response.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Anthropic error: %v", resp.Status)
+	}
+
+	var text strings.Builder
+	for _, content := range response.Content {
+		if content.Type == "text" {
+			text.WriteString(content.Text)
+		}
+	}
+	return text.String(), nil
+}
+
+// anthropicMessages converts messages to the form required by the Messages
+// API, which takes instructions as a separate system prompt and requires
+// messages to alternate between the user and assistant, starting with the
+// user. Messages before the first from the user become the system prompt,
+// later system messages are sent as from the user, and consecutive messages
+// from the same role are combined.
+func anthropicMessages(messages []Message) (string, []anthropicMessage) {
+	var system []string
+	var out []anthropicMessage
+	for _, message := range messages {
+		if len(out) == 0 && message.Role != RoleUser {
+			system = append(system, message.Content)
+			continue
+		}
+		role := message.Role
+		if role == RoleSystem {
+			role = RoleUser
+		}
+		if len(out) > 0 && out[len(out)-1].Role == role {
+			out[len(out)-1].Content += "\n\n" + message.Content
+			continue
+		}
+		out = append(out, anthropicMessage{Role: role, Content: message.Content})
+	}
+	// At least one message is required, so the last instruction is sent as
+	// from the user where there are no others
+	if len(out) == 0 && len(system) > 0 {
+		out = append(out, anthropicMessage{Role: RoleUser, Content: system[len(system)-1]})
+		system = system[:len(system)-1]
+	}
+	return strings.Join(system, "\n\n"), out
+}
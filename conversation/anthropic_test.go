@@ -0,0 +1,74 @@
+package conversation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnthropicMessages(t *testing.T) {
+	tests := []struct {
+		name       string
+		messages   []Message
+		wantSystem string
+		want       []anthropicMessage
+	}{
+		{
+			name: "leading system messages",
+			messages: []Message{
+				{Role: RoleSystem, Content: "You write SQL"},
+				{Role: RoleSystem, Content: "The schema is"},
+				{Role: RoleUser, Content: "How many orders?"},
+			},
+			wantSystem: "You write SQL\n\nThe schema is",
+			want:       []anthropicMessage{{Role: RoleUser, Content: "How many orders?"}},
+		},
+		{
+			name: "leading assistant message",
+			messages: []Message{
+				{Role: RoleAssistant, Content: "Ask me a question"},
+				{Role: RoleUser, Content: "How many orders?"},
+			},
+			wantSystem: "Ask me a question",
+			want:       []anthropicMessage{{Role: RoleUser, Content: "How many orders?"}},
+		},
+		{
+			name: "later system messages merged with user messages",
+			messages: []Message{
+				{Role: RoleSystem, Content: "You write SQL"},
+				{Role: RoleUser, Content: "How many orders?"},
+				{Role: RoleAssistant, Content: "SELECT count(*) FROM orders"},
+				{Role: RoleSystem, Content: "The above query returned the error: no such table"},
+				{Role: RoleUser, Content: "Please correct it"},
+				{Role: RoleAssistant, Content: "SELECT count(*)"},
+				{Role: RoleAssistant, Content: "FROM sales.orders"},
+			},
+			wantSystem: "You write SQL",
+			want: []anthropicMessage{
+				{Role: RoleUser, Content: "How many orders?"},
+				{Role: RoleAssistant, Content: "SELECT count(*) FROM orders"},
+				{Role: RoleUser, Content: "The above query returned the error: no such table\n\nPlease correct it"},
+				{Role: RoleAssistant, Content: "SELECT count(*)\n\nFROM sales.orders"},
+			},
+		},
+		{
+			name: "only system messages",
+			messages: []Message{
+				{Role: RoleSystem, Content: "You write SQL"},
+				{Role: RoleSystem, Content: "How many orders?"},
+			},
+			wantSystem: "You write SQL",
+			want:       []anthropicMessage{{Role: RoleUser, Content: "How many orders?"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			system, got := anthropicMessages(test.messages)
+			if system != test.wantSystem {
+				t.Errorf("got system prompt %q, want %q", system, test.wantSystem)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("\ngot  %+v\nwant %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,349 @@
+package conversation
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/sashabaranov/go-openai"
+	"github.com/theothertomelliott/gptsql/schema"
+)
+
+// contextWindows lists the number of tokens each model can process,
+// including its response
+var contextWindows = map[string]int{
+	openai.GPT3Dot5Turbo:     4096,
+	openai.GPT3Dot5Turbo0301: 4096,
+	"gpt-3.5-turbo-16k":      16384,
+	openai.GPT4:              8192,
+	openai.GPT40314:          8192,
+	openai.GPT432K:           32768,
+	openai.GPT432K0314:       32768,
+}
+
+// claudeContextWindow is the smallest context window of Anthropic's Claude
+// models
+const claudeContextWindow = 100000
+
+// defaultContextWindow is assumed for other models
+const defaultContextWindow = 4096
+
+// defaultMaxTokens limits the length of responses by default. This many
+// tokens are reserved in the context window for the response.
+const defaultMaxTokens = 512
+
+// PromptUsage describes the tokens used by a prompt, and any earlier
+// exchanges removed from it to fit within the model's context window
+type PromptUsage struct {
+	// Tokens is the number of tokens in the prompt
+	Tokens int `json:"tokens"`
+	// Limit is the number of tokens available for the prompt
+	Limit int `json:"limit"`
+	// Summarized lists the questions of earlier exchanges that were
+	// replaced with a summary
+	Summarized []string `json:"summarized,omitempty"`
+	// Dropped lists the questions of earlier exchanges that were left out
+	Dropped []string `json:"dropped,omitempty"`
+}
+
+// fitPrompt returns the messages for a prompt made up of head, the history
+// of the conversation and tail, within the model's context window of the
+// given number of tokens, leaving maxTokens for the response.
+// If the whole history does not fit, the most recent exchanges are kept and
+// older ones are summarized by their questions and queries. Exchanges that
+// do not fit even when summarized are dropped. An error is returned if head
+// and tail alone do not fit.
+func fitPrompt(model string, window, maxTokens int, head []Message, history []Exchange, tail []Message) ([]Message, PromptUsage, error) {
+	usage := PromptUsage{Limit: window - maxTokens}
+	// Every reply is primed with 3 tokens
+	used := 3 + messagesTokens(model, head) + messagesTokens(model, tail)
+	if used > usage.Limit {
+		return nil, usage, fmt.Errorf("prompt needs %v tokens, but only %v are available for %v", used, usage.Limit, model)
+	}
+
+	// Keep as many recent exchanges as fit. If they do not all fit, a
+	// quarter of the space is left for summarizing the rest.
+	historyLimit := usage.Limit
+	var historyTokens int
+	for i := range history {
+		historyTokens += messagesTokens(model, history[i].toMessages())
+	}
+	if used+historyTokens > usage.Limit {
+		historyLimit -= (usage.Limit - used) / 4
+	}
+	kept := len(history)
+	var recent [][]Message
+	for kept > 0 {
+		messages := history[kept-1].toMessages()
+		tokens := messagesTokens(model, messages)
+		if used+tokens > historyLimit {
+			break
+		}
+		used += tokens
+		recent = append([][]Message{messages}, recent...)
+		kept--
+	}
+
+	// Summarize older exchanges, most recent first, until they no longer fit
+	var summary []Message
+	if kept > 0 {
+		const header = "Earlier questions in this conversation, with the queries that answered them:"
+		lines := []string{header}
+		used += messagesTokens(model, []Message{{Role: RoleSystem, Content: header}})
+		first := kept
+		for i := kept - 1; i >= 0; i-- {
+			line := "- " + history[i].summary()
+			tokens := countTokens(model, "\n"+line)
+			if used+tokens > usage.Limit {
+				break
+			}
+			used += tokens
+			lines = append([]string{lines[0], line}, lines[1:]...)
+			first = i
+		}
+		for _, exchange := range history[first:kept] {
+			usage.Summarized = append(usage.Summarized, exchange.Request.Question)
+		}
+		for _, exchange := range history[:first] {
+			usage.Dropped = append(usage.Dropped, exchange.Request.Question)
+		}
+		if len(lines) > 1 {
+			summary = append(summary, Message{
+				Role:    RoleSystem,
+				Content: strings.Join(lines, "\n"),
+			})
+		}
+	}
+
+	messages := append([]Message(nil), head...)
+	messages = append(messages, summary...)
+	for _, exchange := range recent {
+		messages = append(messages, exchange...)
+	}
+	messages = append(messages, tail...)
+	usage.Tokens = 3 + messagesTokens(model, messages)
+
+	if len(usage.Summarized) > 0 || len(usage.Dropped) > 0 {
+		log.Printf(
+			"Summarized %v and dropped %v earlier exchanges to fit the prompt in %v tokens",
+			len(usage.Summarized), len(usage.Dropped), usage.Limit,
+		)
+	}
+	return messages, usage, nil
+}
+
+// summary describes an exchange in a single line
+func (e *Exchange) summary() string {
+	line := e.Request.Question
+	if e.Response != nil && e.Response.Query != "" {
+		query := strings.Join(strings.Fields(e.Response.Query), " ")
+		if len(query) > 100 {
+			query = query[:100] + "..."
+		}
+		line += " -> " + query
+	}
+	return line
+}
+
+// contextWindow returns the number of tokens the model can process
+func contextWindow(model string) int {
+	if window, ok := contextWindows[model]; ok {
+		return window
+	}
+	if strings.HasPrefix(model, "claude-") {
+		return claudeContextWindow
+	}
+	return defaultContextWindow
+}
+
+// messagesTokens counts the tokens in messages, including the tokens used to
+// separate them, as described in OpenAI's cookbook
+func messagesTokens(model string, messages []Message) int {
+	var tokens int
+	for _, message := range messages {
+		tokens += 3 + countTokens(model, message.Role) + countTokens(model, message.Content)
+	}
+	return tokens
+}
+
+// tokenizer is the tokenizer for a model, loaded on first use
+type tokenizer struct {
+	once sync.Once
+	// encoding is nil if the tokenizer could not be loaded
+	encoding *tiktoken.Tiktoken
+}
+
+var (
+	tokenizersMtx sync.Mutex
+	tokenizers    = make(map[string]*tokenizer)
+)
+
+// countTokens counts the tokens in text using the model's tokenizer. Models
+// not from OpenAI are assumed to have a similar tokenizer to GPT-3.5. The
+// tokenizer's data is downloaded on first use, and if this fails, the count
+// is estimated from the length of the text.
+func countTokens(model, text string) int {
+	tokenizersMtx.Lock()
+	t, ok := tokenizers[model]
+	if !ok {
+		t = &tokenizer{}
+		tokenizers[model] = t
+	}
+	tokenizersMtx.Unlock()
+
+	// Only callers counting tokens for this model wait while it loads
+	t.once.Do(func() {
+		var err error
+		t.encoding, err = tiktoken.EncodingForModel(model)
+		if err != nil {
+			t.encoding, err = tiktoken.GetEncoding("cl100k_base")
+		}
+		if err != nil {
+			log.Printf("Estimating token counts, could not load tokenizer for %v: %v", model, err)
+		}
+	})
+
+	if t.encoding == nil {
+		return estimateTokens(text)
+	}
+	return len(t.encoding.Encode(text, nil, nil))
+}
+
+// SchemaTokenCounts counts the tokens in the tables of a schema once for
+// each model, so that selecting the tables relevant to each question does
+// not require counting the schema again
+type SchemaTokenCounts struct {
+	schema schema.Schema
+
+	mtx    sync.Mutex
+	models map[string]*schemaTokenCount
+}
+
+type schemaTokenCount struct {
+	once   sync.Once
+	counts schema.TokenCounts
+}
+
+// NewSchemaTokenCounts returns token counts for the given schema, which are
+// counted when first needed for each model
+func NewSchemaTokenCounts(s schema.Schema) *SchemaTokenCounts {
+	return &SchemaTokenCounts{
+		schema: s,
+		models: make(map[string]*schemaTokenCount),
+	}
+}
+
+// forModel returns the counts using the tokenizer for model
+func (c *SchemaTokenCounts) forModel(model string) schema.TokenCounts {
+	c.mtx.Lock()
+	count, ok := c.models[model]
+	if !ok {
+		count = &schemaTokenCount{}
+		c.models[model] = count
+	}
+	c.mtx.Unlock()
+
+	count.once.Do(func() {
+		count.counts = c.schema.CountTokens(func(text string) int {
+			return countTokens(model, text)
+		})
+	})
+	return count.counts
+}
+
+// estimateTokens approximates the number of tokens in text, using the
+// average of about four characters per token for English text and SQL
+func estimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// tokenizerTimeout limits how long downloading a tokenizer's data can take
+const tokenizerTimeout = 10 * time.Second
+
+func init() {
+	tiktoken.SetBpeLoader(timeoutBpeLoader{})
+}
+
+// timeoutBpeLoader loads tokenizer data as tiktoken's default loader does,
+// but downloads it with a timeout, so that counting tokens does not hang
+// when the network is unavailable
+type timeoutBpeLoader struct{}
+
+func (timeoutBpeLoader) LoadTiktokenBpe(url string) (map[string]int, error) {
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		if err := downloadTokenizer(url); err != nil {
+			return nil, err
+		}
+	}
+	// The default loader reads the file downloaded to its cache
+	return tiktoken.NewDefaultBpeLoader().LoadTiktokenBpe(url)
+}
+
+// tokenizerCachePath returns the path at which tiktoken caches the file at
+// url
+func tokenizerCachePath(url string) string {
+	dir := os.Getenv("TIKTOKEN_CACHE_DIR")
+	if dir == "" {
+		dir = os.Getenv("DATA_GYM_CACHE_DIR")
+	}
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "data-gym-cache")
+	}
+	return filepath.Join(dir, fmt.Sprintf("%x", sha1.Sum([]byte(url))))
+}
+
+// downloadTokenizer downloads the file at url to tiktoken's cache, unless
+// it is already cached
+func downloadTokenizer(url string) error {
+	path := tokenizerCachePath(url)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tokenizerTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("downloading tokenizer: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading tokenizer: %v", resp.Status)
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("downloading tokenizer: %w", err)
+	}
+
+	// Write to a temporary file first, so a partially written file is never
+	// read
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("caching tokenizer: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("caching tokenizer: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("caching tokenizer: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("caching tokenizer: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,115 @@
+package conversation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFitPrompt(t *testing.T) {
+	const model = "stub"
+	exchange := func(question string) Exchange {
+		return Exchange{
+			Request: &Request{Question: question},
+			Response: &Response{
+				Query:   "SELECT id, name, email, created_at FROM customers ORDER BY created_at DESC",
+				DataCsv: strings.Repeat("1,Jane Doe,jane.doe@example.org,2023-01-15 10:30:00\n", 5),
+			},
+		}
+	}
+	history := []Exchange{exchange("first"), exchange("second"), exchange("third"), exchange("fourth")}
+	// longFirst has a first question too long to be summarized in the space
+	// left for the others
+	longFirst := append([]Exchange{exchange(strings.Repeat("Which customers signed up ", 20))}, history[1:]...)
+	head := []Message{{Role: RoleSystem, Content: "You are a helpful assistant"}}
+	tail := []Message{{Role: RoleUser, Content: "How many customers are there?"}}
+
+	// base is the size of the prompt without any history, and last the size
+	// of the most recent exchange
+	base := 3 + messagesTokens(model, head) + messagesTokens(model, tail)
+	last := messagesTokens(model, history[3].toMessages())
+	all := base
+	for _, exchange := range history {
+		all += messagesTokens(model, exchange.toMessages())
+	}
+
+	tests := []struct {
+		name    string
+		history []Exchange
+		limit   int
+		// recent is the number of exchanges kept in full
+		recent     int
+		summarized []string
+		dropped    []string
+		wantErr    bool
+	}{
+		{
+			name:    "whole history fits",
+			history: history,
+			limit:   all,
+			recent:  4,
+		},
+		{
+			name:       "older exchanges summarized",
+			history:    history,
+			limit:      base + 2*last,
+			recent:     1,
+			summarized: []string{"first", "second", "third"},
+		},
+		{
+			name:       "exchanges too long to summarize dropped",
+			history:    longFirst,
+			limit:      base + 2*last,
+			recent:     1,
+			summarized: []string{"second", "third"},
+			dropped:    []string{longFirst[0].Request.Question},
+		},
+		{
+			name:    "only head and tail fit",
+			history: history,
+			limit:   base,
+			dropped: []string{"first", "second", "third", "fourth"},
+		},
+		{
+			name:    "head and tail do not fit",
+			history: history,
+			limit:   base - 1,
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			messages, usage, err := fitPrompt(model, test.limit+defaultMaxTokens, defaultMaxTokens, head, test.history, tail)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if usage.Limit != test.limit || usage.Tokens > usage.Limit {
+				t.Errorf("used %v tokens, over the limit of %v", usage.Tokens, test.limit)
+			}
+			if !reflect.DeepEqual(usage.Summarized, test.summarized) || !reflect.DeepEqual(usage.Dropped, test.dropped) {
+				t.Errorf("summarized %q and dropped %q, want %q and %q", usage.Summarized, usage.Dropped, test.summarized, test.dropped)
+			}
+
+			want := append([]Message(nil), head...)
+			if len(test.summarized) > 0 {
+				want = append(want, messages[len(head)])
+				if summary := messages[len(head)].Content; !strings.HasPrefix(summary, "Earlier questions") || !strings.Contains(summary, "- "+test.summarized[0]+" -> SELECT") {
+					t.Errorf("unexpected summary %q", summary)
+				}
+			}
+			for _, exchange := range test.history[len(test.history)-test.recent:] {
+				want = append(want, exchange.toMessages()...)
+			}
+			want = append(want, tail...)
+			if !reflect.DeepEqual(messages, want) {
+				t.Errorf("\ngot  %+v\nwant %+v", messages, want)
+			}
+		})
+	}
+}
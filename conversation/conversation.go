@@ -3,128 +3,354 @@ package conversation
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 	"strings"
 
 	"github.com/joho/sqltocsv"
-	"github.com/sashabaranov/go-openai"
 	"github.com/theothertomelliott/gptsql/schema"
 )
 
-const model = openai.GPT3Dot5Turbo
+// defaultSchemaTokens leaves room in GPT-3.5's 4,096 token context for the
+// instructions, history and response
+const defaultSchemaTokens = 2000
 
+// defaultCandidates is the number of alternative queries generated for each
+// question by default
+const defaultCandidates = 3
+
+// ErrQueryFailed is returned by Ask when no query answering the question
+// could be run, including any attempts to correct it
+var ErrQueryFailed = errors.New("no query answering the question could be run")
+
+// Option configures a conversation
+type Option func(*Conversation)
+
+// WithSchemaTokens limits the schema sent with each question to the tables
+// most relevant to the conversation, within about maxTokens tokens. If zero,
+// the whole schema is sent. Defaults to 2,000 tokens.
+func WithSchemaTokens(maxTokens int) Option {
+	return func(c *Conversation) {
+		c.schemaTokens = maxTokens
+	}
+}
+
+// WithSchemaTokenCounts shares the token counts of the schema between
+// conversations, so the schema is only counted once for each model. The
+// counts must be for the schema the conversation is created with.
+func WithSchemaTokenCounts(counts *SchemaTokenCounts) Option {
+	return func(c *Conversation) {
+		c.tokenCounts = counts
+	}
+}
+
+// WithContextWindow sets the number of tokens the model can process, for
+// models where this is not known, such as self-hosted models. Defaults to
+// the known context window of the model, or 4,096 tokens.
+func WithContextWindow(tokens int) Option {
+	return func(c *Conversation) {
+		c.contextWindow = tokens
+	}
+}
+
+// Settings control how queries are generated. Unset fields use the
+// defaults.
+type Settings struct {
+	// Model overrides the provider's model
+	Model string `json:"model,omitempty"`
+	// Temperature controls the randomness of queries, from 0 for the most
+	// deterministic. Defaults to the provider's default.
+	Temperature *float32 `json:"temperature,omitempty"`
+	// Candidates is the number of alternative queries generated for each
+	// question, which are run in turn until one succeeds. Only one is
+	// generated when there is no database to run them. Defaults to 3.
+	Candidates int `json:"candidates,omitempty"`
+	// MaxTokens limits the length of each response. Defaults to 512.
+	MaxTokens int `json:"max_tokens,omitempty"`
+	// RepairAttempts is the number of times the model is asked to correct
+	// a query after every candidate fails, by showing it the error. Defaults
+	// to 0.
+	RepairAttempts int `json:"repair_attempts,omitempty"`
+}
+
+// WithSettings sets how queries are generated. Only the fields that are set
+// are applied, so settings can be layered over earlier defaults.
+func WithSettings(settings Settings) Option {
+	return func(c *Conversation) {
+		if settings.Model != "" {
+			c.settings.Model = settings.Model
+		}
+		if settings.Temperature != nil {
+			c.settings.Temperature = settings.Temperature
+		}
+		if settings.Candidates > 0 {
+			c.settings.Candidates = settings.Candidates
+		}
+		if settings.MaxTokens > 0 {
+			c.settings.MaxTokens = settings.MaxTokens
+		}
+		if settings.RepairAttempts > 0 {
+			c.settings.RepairAttempts = settings.RepairAttempts
+		}
+	}
+}
+
+// New creates a conversation about the database with the given schema,
+// using provider to generate queries. If db is nil, queries are generated
+// without being run.
 func New(
-	client *openai.Client,
+	provider Provider,
 	db *sql.DB,
 	dbType string,
 	schema schema.Schema,
+	opts ...Option,
 ) *Conversation {
-	return &Conversation{
-		client: client,
-		db:     db,
-		schema: schema,
+	c := &Conversation{
+		provider:     provider,
+		db:           db,
+		dbType:       dbType,
+		schema:       schema,
+		schemaTokens: defaultSchemaTokens,
+		settings: Settings{
+			Candidates: defaultCandidates,
+			MaxTokens:  defaultMaxTokens,
+		},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.tokenCounts == nil {
+		c.tokenCounts = NewSchemaTokenCounts(schema)
 	}
+	return c
 }
 
 type Conversation struct {
-	client *openai.Client
-	schema schema.Schema
-	db     *sql.DB
-	dbType string
+	provider      Provider
+	settings      Settings
+	contextWindow int
+	schema        schema.Schema
+	schemaTokens  int
+	tokenCounts   *SchemaTokenCounts
+	db            *sql.DB
+	dbType        string
 
 	history []Exchange
 }
 
-func (c *Conversation) schemaPromptMessage() openai.ChatCompletionMessage {
-	return openai.ChatCompletionMessage{
-		Role:    openai.ChatMessageRoleAssistant,
-		Content: fmt.Sprintf("Use the following schema to answer questions\nThe database type is %v\n\n%v\n\n", c.dbType, c.schema),
+// schemaPromptMessage provides the parts of the schema relevant to the
+// given question
+func (c *Conversation) schemaPromptMessage(question string) Message {
+	dialect := c.schema.Dialect
+	if dialect == "" {
+		dialect = c.dbType
+	}
+	relevant := c.schema.Relevant(question, c.schemaTokens, c.tokenCounts.forModel(c.model()))
+	var pruned string
+	if len(relevant.Tables) < len(c.schema.Tables) {
+		pruned = fmt.Sprintf("Only the %v tables most relevant to the question are shown, out of %v\n", len(relevant.Tables), len(c.schema.Tables))
+	}
+	return Message{
+		Role:    RoleAssistant,
+		Content: fmt.Sprintf("Use the following schema to answer questions\nThe database type is %v, only use SQL syntax supported by %v\n%v\n%v\n\n", dialect, dialect, pruned, relevant),
 	}
 }
 
 func (c *Conversation) SampleQuestions() ([]string, error) {
-	resp, err := c.client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model: model,
-			Messages: []openai.ChatCompletionMessage{
-				c.schemaPromptMessage(),
-				{
-					Role: openai.ChatMessageRoleSystem,
-					Content: `
+	messages, _, err := c.fitPrompt([]Message{
+		c.schemaPromptMessage(""),
+		{
+			Role: RoleSystem,
+			Content: `
 					Provide three example questions that may be answered using SQL queries against this database.
 					Ensure that these questions could be turned into SQL queries using only the schema provided.
 					Lean towards questions that aggregate data rather than expecting the user to specify values.
 					Do not provide the SQL queries themselves.
 					Output questions one per line.
 					`,
-				},
-			},
 		},
-	)
+	}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
 
+	responses, err := c.provider.Complete(
+		context.Background(),
+		c.completionRequest(messages, 1),
+	)
 	if err != nil {
-		return nil, fmt.Errorf("ChatCompletion error: %w", err)
+		return nil, err
 	}
 
-	response := resp.Choices[0].Message.Content
-	return strings.Split(response, "\n"), nil
+	return strings.Split(responses[0], "\n"), nil
 }
 
+// Ask generates a query answering the question and runs it. If every
+// attempt fails, the response is returned with an error wrapping
+// ErrQueryFailed and the error from the last attempt, so that the attempts
+// can still be shown. Other errors are returned without a response.
 func (c *Conversation) Ask(req Request) (*Response, error) {
 	res := &Response{}
 
-	var messages []openai.ChatCompletionMessage
-	messages = append(messages, c.schemaPromptMessage())
-	messages = append(messages, openai.ChatCompletionMessage{
-		Role: openai.ChatMessageRoleSystem,
+	// Earlier questions are included when selecting tables, so follow-up
+	// questions can refer to them
+	var questions []string
+	for _, exchange := range c.history {
+		questions = append(questions, exchange.Request.Question)
+	}
+	questions = append(questions, req.Question)
+
+	var instructions []Message
+	instructions = append(instructions, c.schemaPromptMessage(strings.Join(questions, "\n")))
+	instructions = append(instructions, Message{
+		Role: RoleSystem,
 		Content: `You are a chatbot that answers questions about a database in the form of SQL queries.
 		You will only use the content from the schema provided to answer questions.
+		Views are curated for analysis, prefer querying views over base tables where they contain the data needed.
+		Approximate table sizes are given where known, filter or aggregate large tables rather than joining them in full.
+		Where the schema includes a glossary, use its definitions for the business terms it defines.
 		Avoid queries with placeholders.`,
 	})
 
-	for _, exchange := range c.history {
-		messages = append(messages, exchange.toMessages()...)
-	}
-
-	messages = append(messages, openai.ChatCompletionMessage{
-		Role: openai.ChatMessageRoleUser,
+	question := []Message{{
+		Role: RoleUser,
 		Content: fmt.Sprintf(
 			"Please answer this question in the form of an SQL query, do not explain your response:\n%v",
 			req.Question,
 		),
-	})
+	}}
 
-	resp, err := c.client.CreateChatCompletion(
+	// Older exchanges are summarized or dropped if the history does not fit
+	// within the model's context window
+	messages, usage, err := c.fitPrompt(instructions, c.history, question)
+	if err != nil {
+		return nil, err
+	}
+	res.Prompt = &usage
+
+	// Alternative queries are only useful if the first can be run and fails
+	n := c.settings.Candidates
+	if c.db == nil {
+		n = 1
+	}
+	responses, err := c.provider.Complete(
 		context.Background(),
-		openai.ChatCompletionRequest{
-			Model:    model,
-			Messages: messages,
-			N:        3,
-		},
+		c.completionRequest(messages, n),
 	)
-
 	if err != nil {
-		return nil, fmt.Errorf("ChatCompletion error: %w", err)
+		return nil, err
+	}
+
+	for _, response := range responses {
+		c.attempt(res, response, false)
+		if res.Error == nil {
+			break
+		}
+	}
+
+	// If every candidate failed, the model is shown the error from the last
+	// and asked to correct it, along with any earlier corrections. The query
+	// is already in the prompt, so only the error from the database is shown.
+	var repairs []Message
+	for i := 0; res.Error != nil && i < c.settings.RepairAttempts; i++ {
+		failed := res.Query
+		if failed == "" {
+			failed = res.RawText
+		}
+		cause := res.Error
+		var queryErr *queryError
+		if errors.As(cause, &queryErr) {
+			cause = queryErr.err
+		}
+		repairs = append(repairs,
+			Message{Role: RoleAssistant, Content: failed},
+			Message{
+				Role: RoleUser,
+				Content: fmt.Sprintf(
+					"The above query failed with the error:\n%v\nPlease correct the query, do not explain your response.",
+					cause,
+				),
+			},
+		)
+		tail := append(append([]Message(nil), question...), repairs...)
+		messages, usage, err := c.fitPrompt(instructions, c.history, tail)
+		if err != nil {
+			log.Printf("Could not repair query: %v", err)
+			break
+		}
+		res.Prompt = &usage
+		responses, err := c.provider.Complete(
+			context.Background(),
+			c.completionRequest(messages, 1),
+		)
+		if err != nil {
+			log.Printf("Could not repair query: %v", err)
+			break
+		}
+		c.attempt(res, responses[0], true)
 	}
 
 	c.history = append(c.history, Exchange{
 		Request:  &req,
 		Response: res,
 	})
+	if res.Error != nil {
+		return res, fmt.Errorf("%w: %w", ErrQueryFailed, res.Error)
+	}
+	return res, nil
+}
 
-	for _, choice := range resp.Choices {
-		res.Query = choice.Message.Content
-		res.DataCsv, res.Error = c.execQuery(choice.Message.Content)
-		if res.Error == nil {
-			break
-		}
+// attempt extracts the query from a response from the model and runs it,
+// if there is a database to run it against, updating res with the result
+// and recording the attempt
+func (c *Conversation) attempt(res *Response, response string, repair bool) {
+	res.RawText = response
+	res.DataCsv = ""
+	res.Query, res.Error = extractSQL(response)
+	if res.Error == nil && c.db != nil {
+		res.DataCsv, res.Error = c.execQuery(res.Query)
 	}
 
+	attempt := Attempt{
+		Query:   res.Query,
+		RawText: response,
+		Repair:  repair,
+	}
 	if res.Error != nil {
-		return nil, res.Error
+		attempt.Error = res.Error.Error()
 	}
-	return res, nil
+	res.Attempts = append(res.Attempts, attempt)
+}
+
+// model returns the name of the model used by the conversation
+func (c *Conversation) model() string {
+	if c.settings.Model != "" {
+		return c.settings.Model
+	}
+	return c.provider.Model()
+}
+
+// completionRequest requests n responses to messages using the
+// conversation's settings
+func (c *Conversation) completionRequest(messages []Message, n int) CompletionRequest {
+	return CompletionRequest{
+		Model:       c.settings.Model,
+		Messages:    messages,
+		N:           n,
+		MaxTokens:   c.settings.MaxTokens,
+		Temperature: c.settings.Temperature,
+	}
+}
+
+// fitPrompt fits the prompt within the context window of the model
+func (c *Conversation) fitPrompt(head []Message, history []Exchange, tail []Message) ([]Message, PromptUsage, error) {
+	model := c.model()
+	window := c.contextWindow
+	if window <= 0 {
+		window = contextWindow(model)
+	}
+	return fitPrompt(model, window, c.settings.MaxTokens, head, history, tail)
 }
 
 // upTo5Lines returns up to the first 5 lines of the given string
@@ -137,11 +363,25 @@ func upTo5Lines(input string) string {
 	return strings.Join(lines[0:5], "\n")
 }
 
+// queryError is an error from the database when running a query
+type queryError struct {
+	query string
+	err   error
+}
+
+func (e *queryError) Error() string {
+	return fmt.Sprintf("query:\n%v\n%v", e.query, e.err)
+}
+
+func (e *queryError) Unwrap() error {
+	return e.err
+}
+
 // execQuery runs a db query and prints the results in csv format
 func (c *Conversation) execQuery(query string) (string, error) {
 	rows, err := c.db.Query(query)
 	if err != nil {
-		return "", fmt.Errorf("query:\n%v\n%w", query, err)
+		return "", &queryError{query: query, err: err}
 	}
 	defer rows.Close()
 
@@ -0,0 +1,216 @@
+package conversation
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/theothertomelliott/gptsql/schema"
+)
+
+// stubProvider returns each set of responses in turn, recording the
+// requests it receives
+type stubProvider struct {
+	responses [][]string
+	err       error
+	requests  []CompletionRequest
+}
+
+func (p *stubProvider) Complete(_ context.Context, req CompletionRequest) ([]string, error) {
+	p.requests = append(p.requests, req)
+	if p.err != nil {
+		return nil, p.err
+	}
+	if len(p.responses) == 0 {
+		return nil, errors.New("no more responses")
+	}
+	responses := p.responses[0]
+	p.responses = p.responses[1:]
+	return responses, nil
+}
+
+func (p *stubProvider) Model() string {
+	return "stub"
+}
+
+func (p *stubProvider) MaxTemperature() float32 {
+	return 1
+}
+
+func TestAskAttempts(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses [][]string
+		repairs   int
+		// want lists the query of each attempt, and whether it was a repair
+		want    []Attempt
+		wantCsv string
+		// failed is true if every attempt is expected to fail
+		failed bool
+	}{
+		{
+			name:      "first candidate succeeds",
+			responses: [][]string{{"SELECT n FROM numbers", "SELECT 2"}},
+			want:      []Attempt{{Query: "SELECT n FROM numbers"}},
+			wantCsv:   "n\n1\n",
+		},
+		{
+			name:      "later candidate succeeds",
+			responses: [][]string{{"SELECT missing FROM numbers", "no query here", "SELECT n FROM numbers"}},
+			want: []Attempt{
+				{Query: "SELECT missing FROM numbers"},
+				{},
+				{Query: "SELECT n FROM numbers"},
+			},
+			wantCsv: "n\n1\n",
+		},
+		{
+			name: "repair succeeds",
+			responses: [][]string{
+				{"SELECT missing FROM numbers"},
+				{"SELECT n FROM numbers"},
+			},
+			repairs: 2,
+			want: []Attempt{
+				{Query: "SELECT missing FROM numbers"},
+				{Query: "SELECT n FROM numbers", Repair: true},
+			},
+			wantCsv: "n\n1\n",
+		},
+		{
+			name:      "every candidate fails",
+			responses: [][]string{{"SELECT missing FROM numbers", "SELECT n FROM missing"}},
+			want: []Attempt{
+				{Query: "SELECT missing FROM numbers"},
+				{Query: "SELECT n FROM missing"},
+			},
+			failed: true,
+		},
+		{
+			name: "every repair fails",
+			responses: [][]string{
+				{"SELECT missing FROM numbers"},
+				{"SELECT n FROM missing"},
+				{"SELECT m FROM numbers"},
+			},
+			repairs: 2,
+			want: []Attempt{
+				{Query: "SELECT missing FROM numbers"},
+				{Query: "SELECT n FROM missing", Repair: true},
+				{Query: "SELECT m FROM numbers", Repair: true},
+			},
+			failed: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := numbersDB(t)
+			provider := &stubProvider{responses: test.responses}
+			c := New(provider, db, "sqlite3", schema.Schema{},
+				WithContextWindow(4096),
+				WithSettings(Settings{RepairAttempts: test.repairs}),
+			)
+
+			res, err := c.Ask(Request{Question: "What numbers are there?"})
+			if test.failed {
+				if !errors.Is(err, ErrQueryFailed) {
+					t.Fatalf("expected ErrQueryFailed, got %v", err)
+				}
+				if res == nil || res.Error == nil {
+					t.Fatalf("expected a response with an error, got %+v", res)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(res.Attempts) != len(test.want) {
+				t.Fatalf("expected %v attempts, got %+v", len(test.want), res.Attempts)
+			}
+			for i, want := range test.want {
+				got := res.Attempts[i]
+				if got.Query != want.Query || got.Repair != want.Repair {
+					t.Errorf("attempt %v: got query %q, repair %v, want query %q, repair %v", i, got.Query, got.Repair, want.Query, want.Repair)
+				}
+				succeeded := !test.failed && i == len(test.want)-1
+				if succeeded != (got.Error == "") {
+					t.Errorf("attempt %v: unexpected error %q", i, got.Error)
+				}
+			}
+			if res.DataCsv != test.wantCsv {
+				t.Errorf("got data %q, want %q", res.DataCsv, test.wantCsv)
+			}
+			if len(c.history) != 1 {
+				t.Errorf("expected the exchange to be recorded in the history, got %v", len(c.history))
+			}
+		})
+	}
+}
+
+// TestAskRepairPrompt checks that the model is shown the failed query once,
+// followed by the error from the database
+func TestAskRepairPrompt(t *testing.T) {
+	provider := &stubProvider{responses: [][]string{
+		{"SELECT missing FROM numbers"},
+		{"SELECT n FROM numbers"},
+	}}
+	c := New(provider, numbersDB(t), "sqlite3", schema.Schema{},
+		WithContextWindow(4096),
+		WithSettings(Settings{RepairAttempts: 1}),
+	)
+	res, err := c.Ask(Request{Question: "What numbers are there?"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provider.requests) != 2 {
+		t.Fatalf("expected 2 requests, got %v", len(provider.requests))
+	}
+	repair := provider.requests[1]
+	if want := 3 + messagesTokens("stub", repair.Messages); res.Prompt == nil || res.Prompt.Tokens != want {
+		t.Errorf("expected the usage of the repair prompt, %v tokens, got %+v", want, res.Prompt)
+	}
+	if repair.N != 1 {
+		t.Errorf("expected one repaired query to be requested, got %v", repair.N)
+	}
+	messages := repair.Messages
+	failed, correction := messages[len(messages)-2], messages[len(messages)-1]
+	if failed.Role != RoleAssistant || failed.Content != "SELECT missing FROM numbers" {
+		t.Errorf("unexpected failed query message: %+v", failed)
+	}
+	if correction.Role != RoleUser || !strings.Contains(correction.Content, "no such column: missing") {
+		t.Errorf("expected the database error in the correction request, got %q", correction.Content)
+	}
+	if strings.Contains(correction.Content, "SELECT") {
+		t.Errorf("expected the query to be left out of the correction request, got %q", correction.Content)
+	}
+}
+
+func TestAskProviderError(t *testing.T) {
+	provider := &stubProvider{err: errors.New("unavailable")}
+	c := New(provider, numbersDB(t), "sqlite3", schema.Schema{}, WithContextWindow(4096))
+	res, err := c.Ask(Request{Question: "What numbers are there?"})
+	if err == nil || errors.Is(err, ErrQueryFailed) || res != nil {
+		t.Fatalf("expected the provider error without a response, got %+v, %v", res, err)
+	}
+	if len(c.history) != 0 {
+		t.Errorf("expected no exchange in the history, got %v", len(c.history))
+	}
+}
+
+// numbersDB returns an in-memory database with a table of numbers
+func numbersDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// Each connection to an in-memory database has its own data
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("CREATE TABLE numbers (n INTEGER); INSERT INTO numbers VALUES (1);"); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
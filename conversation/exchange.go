@@ -2,8 +2,6 @@ package conversation
 
 import (
 	"fmt"
-
-	"github.com/sashabaranov/go-openai"
 )
 
 type Exchange struct {
@@ -16,34 +14,59 @@ type Request struct {
 }
 
 type Response struct {
-	Query   string `json:"query"`
+	// Query is the SQL query extracted from RawText
+	Query string `json:"query"`
+	// RawText is the response from the model
+	RawText string `json:"raw_text"`
 	DataCsv string `json:"data_csv"`
 	Error   error  `json:"error,omitempty"`
+	// Prompt describes the tokens used by the last prompt sent to answer the
+	// question, including any corrections, and any earlier exchanges left
+	// out to fit the model's context window
+	Prompt *PromptUsage `json:"prompt,omitempty"`
+	// Attempts lists each query tried in turn, ending with the query above
+	Attempts []Attempt `json:"attempts,omitempty"`
+}
+
+// Attempt is a query generated to answer a question, and its result
+type Attempt struct {
+	Query   string `json:"query"`
+	RawText string `json:"raw_text"`
+	Error   string `json:"error,omitempty"`
+	// Repair is set if the query was generated to correct the previous
+	// attempt
+	Repair bool `json:"repair,omitempty"`
 }
 
-func (e *Exchange) toMessages() []openai.ChatCompletionMessage {
-	var messages []openai.ChatCompletionMessage
-	messages = append(messages, openai.ChatCompletionMessage{
-		Role: openai.ChatMessageRoleUser,
+func (e *Exchange) toMessages() []Message {
+	var messages []Message
+	messages = append(messages, Message{
+		Role: RoleUser,
 		Content: fmt.Sprintf(
 			"Please answer this question in the form of an SQL query, do not explain your response:\n%v",
 			e.Request.Question,
 		),
 	})
 	if e.Response != nil {
-		messages = append(messages, openai.ChatCompletionMessage{
-			Role:    openai.ChatMessageRoleAssistant,
-			Content: e.Response.Query,
+		// Where no query could be extracted, the model is shown its response
+		// along with the error
+		content := e.Response.Query
+		if content == "" {
+			content = e.Response.RawText
+		}
+		messages = append(messages, Message{
+			Role:    RoleAssistant,
+			Content: content,
 		})
 		if e.Response.DataCsv != "" {
-			messages = append(messages, openai.ChatCompletionMessage{
-				Role:    openai.ChatMessageRoleSystem,
+			messages = append(messages, Message{
+				Role:    RoleSystem,
 				Content: fmt.Sprintf("Sample data from the above query:\n%v", upTo5Lines(e.Response.DataCsv)),
 			})
 		}
 		if e.Response.Error != nil {
-			messages = append(messages, openai.ChatCompletionMessage{
-				Role:    openai.ChatMessageRoleSystem,
+			messages = append(messages, Message{
+				Role:    RoleSystem,
 				Content: fmt.Sprintf("The above query returned the error: %v", e.Response.Error),
 			})
 		}
@@ -0,0 +1,268 @@
+package conversation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// fencePattern matches a Markdown code block, capturing its language and
+// content
+var fencePattern = regexp.MustCompile("(?s)```([A-Za-z0-9_+-]*)[^\\S\\n]*\\n?(.*?)```")
+
+// inlineCodePattern matches a Markdown code span within a line
+var inlineCodePattern = regexp.MustCompile("`([^`\\n]+)`")
+
+// readKeywords are the words that may start a query that only reads data
+var readKeywords = []string{"select", "with", "values"}
+
+// writeKeywords are the words that start, or appear in, statements that
+// modify the database or its schema
+var writeKeywords = []string{
+	"insert", "update", "delete", "merge", "create", "alter", "drop",
+	"truncate", "grant", "revoke",
+}
+
+// startPattern matches a statement keyword, and any opening parentheses
+// before it, at the start of a line or after a colon, as in "Here is the
+// query: SELECT ..."
+var startPattern = regexp.MustCompile(`(?im)(?:^|:)[^\S\n]*(\(*[^\S\n]*(?:` +
+	strings.Join(append(append([]string(nil), readKeywords...), writeKeywords...), "|") + `))\b`)
+
+var (
+	// selectPattern matches a SELECT that does not read from a table, such
+	// as SELECT 1 or SELECT now(), where the first item of the list is a
+	// literal, a wildcard or a function call
+	selectPattern = regexp.MustCompile(`^select\s+((distinct|all)\s+)?([0-9'"*(-]|[a-z_][a-z0-9_]*\s*\()`)
+	// withPattern matches the start of a common table expression, as in
+	// WITH totals AS (
+	withPattern = regexp.MustCompile(`^with\s+(recursive\s+)?[a-z_"\x60\[][^\s(]*\s*(\([^)]*\)\s*)?as\s+((not\s+)?materialized\s+)?\(`)
+	// valuesPattern matches the start of a VALUES list
+	valuesPattern = regexp.MustCompile(`^values\s*\(`)
+	// sentenceEndPattern matches the end of a sentence, which is not found
+	// in SQL outside of strings and comments
+	sentenceEndPattern = regexp.MustCompile(`[a-z)]\.(\s|$)`)
+	wordPattern        = regexp.MustCompile(`[a-z_][a-z0-9_$]*`)
+	firstWordPattern   = regexp.MustCompile(`^[a-z_]+`)
+)
+
+// extractSQL returns the SQL query in a response from the model, which may
+// be in a Markdown code block or code span, or surrounded by prose. An error
+// is returned if no query is found, if the query modifies the database, or
+// if the response contains more than one statement.
+func extractSQL(text string) (string, error) {
+	var modifies bool
+	for _, candidate := range candidates(text) {
+		statement, rest := splitStatement(candidate)
+		statement = strings.TrimSpace(statement)
+		switch {
+		case isQuery(statement):
+		case isStatement(statement):
+			modifies = true
+			continue
+		default:
+			continue
+		}
+
+		// Text after the query is allowed if it is an explanation rather
+		// than another statement
+		if isStatement(rest) {
+			return "", fmt.Errorf("response contains more than one SQL statement: %v", text)
+		}
+		if keyword := writeKeyword(statement); keyword != "" {
+			return "", fmt.Errorf("only queries that read data can be run, but the query uses %v: %v", strings.ToUpper(keyword), statement)
+		}
+		return statement, nil
+	}
+	if modifies {
+		return "", fmt.Errorf("only queries that read data can be run: %v", text)
+	}
+	return "", fmt.Errorf("no SQL query found in response: %v", text)
+}
+
+// candidates returns the text that may contain the query, in order of
+// preference: code blocks, code spans, and then each point in the prose at
+// which a statement may start
+func candidates(text string) []string {
+	var out []string
+	for _, match := range fencePattern.FindAllStringSubmatch(text, -1) {
+		language := strings.ToLower(match[1])
+		if language == "" || strings.HasSuffix(language, "sql") {
+			out = append(out, match[2])
+		}
+	}
+	prose := fencePattern.ReplaceAllString(text, "\n\n")
+	for _, match := range inlineCodePattern.FindAllStringSubmatch(prose, -1) {
+		out = append(out, match[1])
+	}
+	for _, loc := range startPattern.FindAllStringSubmatchIndex(prose, -1) {
+		out = append(out, proseStatement(prose[loc[2]:]))
+	}
+	return out
+}
+
+// proseStatement returns the start of text up to where prose resumes. A
+// statement not terminated by a semicolon ends at a blank line, or before a
+// line ending a sentence.
+func proseStatement(text string) string {
+	masked := maskSQL(text)
+	if semicolon := strings.IndexByte(masked, ';'); semicolon >= 0 && !strings.Contains(masked[:semicolon], "\n\n") {
+		return text
+	}
+	if end := strings.Index(masked, "\n\n"); end >= 0 {
+		text, masked = text[:end], masked[:end]
+	}
+	lines := strings.SplitAfter(masked, "\n")
+	var end int
+	for i, line := range lines {
+		if i > 0 && sentenceEndPattern.MatchString(strings.ToLower(strings.TrimSpace(line))) {
+			break
+		}
+		end += len(line)
+	}
+	return text[:end]
+}
+
+// isQuery reports whether statement has the shape of a query that reads
+// data, rather than prose that happens to start with a keyword
+func isQuery(statement string) bool {
+	masked := normalize(statement)
+	if sentenceEndPattern.MatchString(masked) {
+		return false
+	}
+	switch firstWord(masked) {
+	case "select":
+		return hasWord(masked, "from") || selectPattern.MatchString(masked)
+	case "with":
+		return withPattern.MatchString(masked)
+	case "values":
+		return valuesPattern.MatchString(masked)
+	}
+	return false
+}
+
+// isStatement reports whether text starts with a statement, including
+// statements that modify the database
+func isStatement(text string) bool {
+	if isQuery(text) {
+		return true
+	}
+	masked := normalize(text)
+	return contains(writeKeywords, firstWord(masked)) && !sentenceEndPattern.MatchString(masked)
+}
+
+// writeKeyword returns the first word of the query that modifies the
+// database, such as the DELETE in a Postgres common table expression, or
+// the UPDATE in SELECT ... FOR UPDATE
+func writeKeyword(query string) string {
+	for _, word := range wordPattern.FindAllString(normalize(query), -1) {
+		if contains(writeKeywords, word) {
+			return word
+		}
+	}
+	return ""
+}
+
+// normalize returns text in lower case, with its comments, the contents of
+// its strings and quoted identifiers, and any opening parentheses removed
+func normalize(text string) string {
+	return strings.TrimLeft(strings.ToLower(maskSQL(text)), "( \t\r\n")
+}
+
+func firstWord(text string) string {
+	return firstWordPattern.FindString(text)
+}
+
+func hasWord(text, word string) bool {
+	return contains(wordPattern.FindAllString(text, -1), word)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// splitStatement splits query after the first semicolon that ends a
+// statement, ignoring semicolons in strings, quoted identifiers and
+// comments. The semicolon is not included in the statement.
+func splitStatement(query string) (statement, rest string) {
+	if i := strings.IndexByte(maskSQL(query), ';'); i >= 0 {
+		return query[:i], query[i+1:]
+	}
+	return query, ""
+}
+
+// maskSQL returns query with the contents of its strings and quoted
+// identifiers replaced by x, and its comments replaced by spaces, so that
+// its structure can be matched without being confused by their contents.
+// Every byte is replaced, so positions in the result match the query.
+func maskSQL(query string) string {
+	masked := []byte(query)
+	fill := func(from, to int, with byte) {
+		for i := from; i < to && i < len(masked); i++ {
+			if masked[i] != '\n' {
+				masked[i] = with
+			}
+		}
+	}
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			end := skipQuoted(query, i, c)
+			fill(i+1, end, 'x')
+			i = end
+		case strings.HasPrefix(query[i:], "--"):
+			end := skipUntil(query, i, "\n")
+			fill(i, end, ' ')
+			i = end
+		case strings.HasPrefix(query[i:], "/*"):
+			end := skipUntil(query, i+2, "*/")
+			fill(i, end+1, ' ')
+			i = end
+		case c == '$':
+			// Postgres dollar-quoted strings, such as $$text$$ or $tag$text$tag$
+			if tag := dollarTag(query[i:]); tag != "" {
+				end := skipUntil(query, i+len(tag), tag)
+				fill(i+len(tag), end-len(tag)+1, 'x')
+				i = end
+			}
+		}
+	}
+	return string(masked)
+}
+
+// skipQuoted returns the index of the quote ending the quoted text starting
+// at i, where doubled quotes are escapes
+func skipQuoted(query string, i int, quote byte) int {
+	for j := i + 1; j < len(query); j++ {
+		if query[j] == quote {
+			if j+1 < len(query) && query[j+1] == quote {
+				j++
+				continue
+			}
+			return j
+		}
+	}
+	return len(query)
+}
+
+// skipUntil returns the index of the last byte of the first occurrence of
+// end after i, or the end of query if there is none
+func skipUntil(query string, i int, end string) int {
+	if j := strings.Index(query[i:], end); j >= 0 {
+		return i + j + len(end) - 1
+	}
+	return len(query)
+}
+
+var dollarTagPattern = regexp.MustCompile(`^\$[A-Za-z_]*\$`)
+
+// dollarTag returns the tag opening a dollar-quoted string at the start of
+// text, if any
+func dollarTag(text string) string {
+	return dollarTagPattern.FindString(text)
+}
@@ -0,0 +1,174 @@
+package conversation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExtractSQL(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+		// err is a substring of the expected error, if any
+		err string
+	}{
+		{
+			name: "bare query",
+			text: "SELECT * FROM orders",
+			want: "SELECT * FROM orders",
+		},
+		{
+			name: "terminated query",
+			text: "SELECT * FROM orders;",
+			want: "SELECT * FROM orders",
+		},
+		{
+			name: "code block with prose",
+			text: "Here is the query:\n```sql\nSELECT count(*)\nFROM orders;\n```\nThis counts orders.",
+			want: "SELECT count(*)\nFROM orders",
+		},
+		{
+			name: "code block without language",
+			text: "```\nWITH x AS (SELECT 1) SELECT * FROM x\n```",
+			want: "WITH x AS (SELECT 1) SELECT * FROM x",
+		},
+		{
+			name: "code block with comment",
+			text: "```sql\n-- top customers\nSELECT * FROM customers\n```",
+			want: "-- top customers\nSELECT * FROM customers",
+		},
+		{
+			name: "code block that is not sql",
+			text: "```\nno sql here\n```\nSELECT 5",
+			want: "SELECT 5",
+		},
+		{
+			name: "inline code span",
+			text: "`SELECT count(*) FROM orders`",
+			want: "SELECT count(*) FROM orders",
+		},
+		{
+			name: "inline code span in prose",
+			text: "You can run `SELECT name FROM users` to list them.",
+			want: "SELECT name FROM users",
+		},
+		{
+			name: "query after colon",
+			text: "Here is the query: SELECT 1;",
+			want: "SELECT 1",
+		},
+		{
+			name: "prose line starting with keyword before query",
+			text: "With the given schema, you can use:\n\nSELECT * FROM t;",
+			want: "SELECT * FROM t",
+		},
+		{
+			name: "prose keyword before colon",
+			text: "Show me: SELECT 1",
+			want: "SELECT 1",
+		},
+		{
+			name: "prose line starting with write keyword",
+			text: "Update: the table is called orders.\nSELECT * FROM orders",
+			want: "SELECT * FROM orders",
+		},
+		{
+			name: "trailing prose after semicolon",
+			text: "SELECT 1;\nWith this query you get the count.",
+			want: "SELECT 1",
+		},
+		{
+			name: "trailing prose without semicolon",
+			text: "SELECT * FROM t\nThis returns every row.",
+			want: "SELECT * FROM t",
+		},
+		{
+			name: "trailing prose after blank line",
+			text: "To select the rows, use:\n\nSELECT name FROM users\n\nThis returns names.",
+			want: "SELECT name FROM users",
+		},
+		{
+			name: "trailing comment",
+			text: "SELECT 1;\n-- done\n",
+			want: "SELECT 1",
+		},
+		{
+			name: "semicolons in strings and comments",
+			text: "```postgresql\nselect ';' as semi, $$a;b$$ -- c;\nfrom t;\n```",
+			want: "select ';' as semi, $$a;b$$ -- c;\nfrom t",
+		},
+		{
+			name: "escaped quote",
+			text: "SELECT 'it''s; ok' FROM t",
+			want: "SELECT 'it''s; ok' FROM t",
+		},
+		{
+			name: "parenthesized union",
+			text: "(SELECT 1) UNION (SELECT 2)",
+			want: "(SELECT 1) UNION (SELECT 2)",
+		},
+		{
+			name: "keywords in strings",
+			text: "SELECT * FROM orders WHERE status = 'deleted. update later'",
+			want: "SELECT * FROM orders WHERE status = 'deleted. update later'",
+		},
+		{
+			name: "no query",
+			text: "I cannot answer this question with the schema provided.",
+			err:  "no SQL query found",
+		},
+		{
+			name: "prose starting with select",
+			text: "Select the orders table to see them",
+			err:  "no SQL query found",
+		},
+		{
+			name: "two statements",
+			text: "SELECT 1;\nSELECT 2;",
+			err:  "more than one SQL statement",
+		},
+		{
+			name: "query followed by drop",
+			text: "SELECT 1; DROP TABLE users;",
+			err:  "more than one SQL statement",
+		},
+		{
+			name: "drop",
+			text: "DROP TABLE users;",
+			err:  "only queries that read data",
+		},
+		{
+			name: "delete in code block",
+			text: "```sql\nDELETE FROM orders WHERE id = 1\n```",
+			err:  "only queries that read data",
+		},
+		{
+			name: "update",
+			text: "UPDATE orders SET total = 0",
+			err:  "only queries that read data",
+		},
+		{
+			name: "data-modifying common table expression",
+			text: "WITH gone AS (DELETE FROM orders RETURNING *) SELECT count(*) FROM gone",
+			err:  "uses DELETE",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := extractSQL(test.text)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %q, %v", test.err, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package conversation
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Roles of the participants in a chat
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message is a message in a chat with a model
+type Message struct {
+	Role    string
+	Content string
+}
+
+// CompletionRequest requests responses to a chat
+type CompletionRequest struct {
+	// Model overrides the provider's model, if set
+	Model    string
+	Messages []Message
+	// N is the number of alternative responses requested
+	N int
+	// MaxTokens limits the length of each response
+	MaxTokens int
+	// Temperature controls the randomness of responses, using the
+	// provider's default if nil
+	Temperature *float32
+}
+
+// Provider generates chat completions using a language model
+type Provider interface {
+	// Complete returns up to N alternative responses to the messages, and
+	// at least one unless an error is returned
+	Complete(ctx context.Context, req CompletionRequest) ([]string, error)
+	// Model returns the name of the model used by default, to determine its
+	// context window and tokenizer
+	Model() string
+	// MaxTemperature returns the highest temperature accepted by the
+	// provider's API
+	MaxTemperature() float32
+}
+
+// NewOpenAIProvider returns a provider using the OpenAI chat completions
+// API. If baseURL is set, requests are sent there instead, allowing any
+// server with an OpenAI-compatible API to be used, such as vLLM, llama.cpp
+// or Ollama.
+func NewOpenAIProvider(token, baseURL, model string) Provider {
+	config := openai.DefaultConfig(token)
+	if baseURL != "" {
+		config.BaseURL = baseURL
+	}
+	return &openAIProvider{
+		client: openai.NewClientWithConfig(config),
+		model:  model,
+	}
+}
+
+type openAIProvider struct {
+	client *openai.Client
+	model  string
+}
+
+func (p *openAIProvider) Model() string {
+	return p.model
+}
+
+func (p *openAIProvider) MaxTemperature() float32 {
+	return 2
+}
+
+func (p *openAIProvider) Complete(ctx context.Context, req CompletionRequest) ([]string, error) {
+	var messages []openai.ChatCompletionMessage
+	for _, message := range req.Messages {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    message.Role,
+			Content: message.Content,
+		})
+	}
+	chatReq := openai.ChatCompletionRequest{
+		Model:     p.model,
+		Messages:  messages,
+		N:         req.N,
+		MaxTokens: req.MaxTokens,
+	}
+	if req.Model != "" {
+		chatReq.Model = req.Model
+	}
+	if req.Temperature != nil {
+		chatReq.Temperature = *req.Temperature
+		// A temperature of zero is omitted from the request, which would
+		// use the default of 1 instead
+		if chatReq.Temperature == 0 {
+			chatReq.Temperature = math.SmallestNonzeroFloat32
+		}
+	}
+	resp, err := p.client.CreateChatCompletion(ctx, chatReq)
+	if err != nil {
+		return nil, fmt.Errorf("ChatCompletion error: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("ChatCompletion error: no choices returned")
+	}
+
+	var responses []string
+	for _, choice := range resp.Choices {
+		responses = append(responses, choice.Message.Content)
+	}
+	return responses, nil
+}
@@ -24,9 +24,15 @@ type client struct {
 	newConversationEndpoint endpoint.Endpoint
 	sampleQuestionsEndpoint endpoint.Endpoint
 	askEndpoint             endpoint.Endpoint
+	reloadSchemaEndpoint    endpoint.Endpoint
+	tablesEndpoint          endpoint.Endpoint
+	exportSchemaURL         *url.URL
 }
 
-func NewClient(host string) *client {
+// NewClient returns a client for the server at host. adminToken is sent
+// with requests to admin endpoints, such as ReloadSchema, and may be empty
+// if they are not used.
+func NewClient(host string, adminToken string) *client {
 	c := &client{
 		client: http.DefaultClient,
 	}
@@ -88,13 +94,57 @@ func NewClient(host string) *client {
 		},
 	).Endpoint()
 
+	reloadSchemaURL, err := url.Parse(fmt.Sprintf("%v/admin/reload-schema", host))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	c.reloadSchemaEndpoint = httptransport.NewClient(
+		"POST",
+		reloadSchemaURL,
+		encodeRequest,
+		func(_ context.Context, r *http.Response) (interface{}, error) {
+			var response ReloadSchemaResponse
+			if err := json.NewDecoder(r.Body).Decode(&response); err != nil {
+				fmt.Println("Error decoding response body: ", err)
+				return nil, err
+			}
+			return response, nil
+		},
+		httptransport.ClientBefore(httptransport.SetRequestHeader("Authorization", "Bearer "+adminToken)),
+	).Endpoint()
+
+	c.exportSchemaURL, err = url.Parse(fmt.Sprintf("%v/schema", host))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tablesURL, err := url.Parse(fmt.Sprintf("%v/tables", host))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	c.tablesEndpoint = httptransport.NewClient(
+		"GET",
+		tablesURL,
+		encodeRequest,
+		func(_ context.Context, r *http.Response) (interface{}, error) {
+			var response TablesResponse
+			if err := json.NewDecoder(r.Body).Decode(&response); err != nil {
+				fmt.Println("Error decoding response body: ", err)
+				return nil, err
+			}
+			return response, nil
+		},
+	).Endpoint()
+
 	return c
 }
 
-func (c *client) NewConversation() (ConversationID, error) {
+func (c *client) NewConversation(settings conversation.Settings) (ConversationID, error) {
 	response, err := c.newConversationEndpoint(
 		context.Background(),
-		NewConversationRequest{},
+		NewConversationRequest{Settings: settings},
 	)
 	if err != nil {
 		return "", err
@@ -135,19 +185,80 @@ func (c *client) Ask(cid ConversationID, question string) (*conversation.Respons
 		return nil, err
 	}
 	resp := response.(AskResponse)
-	if resp.Err != "" {
+	// Errors from queries are returned with the attempts that caused them
+	if resp.Err != "" && len(resp.Attempts) == 0 {
 		return nil, fmt.Errorf(resp.Err)
 	}
 	out := &conversation.Response{}
 	out.Query = resp.Query
+	out.RawText = resp.RawText
 	out.DataCsv = resp.DataCsv
+	out.Attempts = resp.Attempts
+	out.Prompt = resp.Prompt
 	if resp.Err != "" {
 		out.Error = fmt.Errorf(resp.Err)
+		return out, fmt.Errorf("%w: %v", conversation.ErrQueryFailed, resp.Err)
 	}
 
 	return out, nil
 }
 
+func (c *client) ReloadSchema() (int, error) {
+	response, err := c.reloadSchemaEndpoint(
+		context.Background(),
+		ReloadSchemaRequest{},
+	)
+	if err != nil {
+		return 0, err
+	}
+	resp := response.(ReloadSchemaResponse)
+	if resp.Err != "" {
+		return 0, fmt.Errorf(resp.Err)
+	}
+	return resp.Tables, nil
+}
+
+func (c *client) Tables() ([]TableInfo, error) {
+	response, err := c.tablesEndpoint(
+		context.Background(),
+		TablesRequest{},
+	)
+	if err != nil {
+		return nil, err
+	}
+	resp := response.(TablesResponse)
+	if resp.Err != "" {
+		return nil, fmt.Errorf(resp.Err)
+	}
+	return resp.Tables, nil
+}
+
+// ExportSchema requests the schema directly rather than through a go-kit
+// endpoint, as the format is passed in the query string and the response
+// is not JSON encoded.
+func (c *client) ExportSchema(format string) (string, error) {
+	u := *c.exportSchemaURL
+	u.RawQuery = url.Values{"format": []string{format}}.Encode()
+	resp, err := c.client.Get(u.String())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var response ExportSchemaResponse
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			return "", fmt.Errorf("exporting schema: %v", resp.Status)
+		}
+		return "", fmt.Errorf(response.Err)
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
 func encodeRequest(_ context.Context, req *http.Request, request interface{}) error {
 	var buf bytes.Buffer
 	err := json.NewEncoder(&buf).Encode(request)
@@ -2,15 +2,19 @@ package server
 
 import (
 	"context"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"strings"
+	"sync"
 
 	"github.com/go-kit/kit/endpoint"
 	httptransport "github.com/go-kit/kit/transport/http"
 	"github.com/google/uuid"
-	"github.com/sashabaranov/go-openai"
 
 	"github.com/theothertomelliott/gptsql/conversation"
 	"github.com/theothertomelliott/gptsql/schema"
@@ -21,41 +25,172 @@ type ConversationID string
 var ErrConversationNotFound = fmt.Errorf("conversation not found")
 
 type Server interface {
-	NewConversation() (ConversationID, error)
+	// NewConversation starts a conversation, generating queries with the
+	// given settings over the server's defaults. An error is returned if
+	// the settings exceed the server's limits.
+	NewConversation(settings conversation.Settings) (ConversationID, error)
 	SampleQuestions(ConversationID) ([]string, error)
 	Ask(cid ConversationID, question string) (*conversation.Response, error)
+	// ReloadSchema reloads the database schema and returns the number of
+	// tables loaded. Only conversations started after the reload use the
+	// new schema.
+	ReloadSchema() (int, error)
+	// Tables lists the tables in the current schema, with their sizes
+	Tables() ([]TableInfo, error)
+	// ExportSchema returns the current schema in the given format, as
+	// supported by schema.Schema.Export
+	ExportSchema(format string) (string, error)
 
 	// TODO: Allow editing the SQL for a given question
 }
 
+// Limits restrict the settings that may be requested for a conversation
+type Limits struct {
+	// Models lists the models that may be requested, in addition to the
+	// provider's default model
+	Models []string
+	// MaxCandidates limits the number of alternative queries generated for
+	// each question
+	MaxCandidates int
+	// MaxTokens limits the length of each response
+	MaxTokens int
+	// MaxRepairAttempts limits the number of times the model is asked to
+	// correct a failing query
+	MaxRepairAttempts int
+}
+
+// DefaultLimits allow up to 5 alternative queries, responses of up to 2,048
+// tokens and 3 attempts to correct a failing query, using only the
+// provider's default model
+var DefaultLimits = Limits{
+	MaxCandidates:     5,
+	MaxTokens:         2048,
+	MaxRepairAttempts: 3,
+}
+
+// check returns an error if the settings exceed the limits, or the
+// temperature accepted by the provider
+func (l Limits) check(settings conversation.Settings, provider conversation.Provider) error {
+	if settings.Model != "" && settings.Model != provider.Model() && !contains(l.Models, settings.Model) {
+		return fmt.Errorf("model %v is not allowed", settings.Model)
+	}
+	if t, max := settings.Temperature, provider.MaxTemperature(); t != nil && (*t < 0 || *t > max) {
+		return fmt.Errorf("temperature must be between 0 and %v", max)
+	}
+	if settings.Candidates < 0 || settings.Candidates > l.MaxCandidates {
+		return fmt.Errorf("candidates must be between 1 and %v", l.MaxCandidates)
+	}
+	if settings.MaxTokens < 0 || settings.MaxTokens > l.MaxTokens {
+		return fmt.Errorf("max_tokens must be between 1 and %v", l.MaxTokens)
+	}
+	if settings.RepairAttempts < 0 || settings.RepairAttempts > l.MaxRepairAttempts {
+		return fmt.Errorf("repair_attempts must be between 0 and %v", l.MaxRepairAttempts)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// SchemaLoader loads the current schema of the database
+type SchemaLoader func() (schema.Schema, error)
+
 type conversationServer struct {
+	// mtx guards conversations, schema and schemaTokens
+	mtx           sync.RWMutex
 	conversations map[ConversationID]*conversation.Conversation
+	schema        schema.Schema
+	// schemaTokens are shared by the conversations using schema, so it is
+	// only counted once for each model
+	schemaTokens *conversation.SchemaTokenCounts
+
+	// reloadMtx ensures only one schema reload runs at a time
+	reloadMtx  sync.Mutex
+	loadSchema SchemaLoader
 
-	client *openai.Client
-	db     *sql.DB
-	dbType string
-	schema schema.Schema
+	provider conversation.Provider
+	limits   Limits
+	db       *sql.DB
+	dbType   string
+	options  []conversation.Option
 }
 
-func New(client *openai.Client, db *sql.DB, dbType string, schema schema.Schema) Server {
+// New creates a server using the given schema for new conversations.
+// loadSchema is used to reload the schema, and may be nil if reloading is
+// not supported. The options are applied to every conversation, and set the
+// defaults for settings requested for each conversation within limits.
+func New(provider conversation.Provider, limits Limits, db *sql.DB, dbType string, schema schema.Schema, loadSchema SchemaLoader, opts ...conversation.Option) Server {
 	return &conversationServer{
 		conversations: make(map[ConversationID]*conversation.Conversation),
+		schema:        schema,
+		schemaTokens:  conversation.NewSchemaTokenCounts(schema),
+		loadSchema:    loadSchema,
 
-		client: client,
-		db:     db,
-		dbType: dbType,
-		schema: schema,
+		provider: provider,
+		limits:   limits,
+		db:       db,
+		dbType:   dbType,
+		options:  opts,
 	}
 }
 
-func (s *conversationServer) NewConversation() (ConversationID, error) {
+func (s *conversationServer) NewConversation(settings conversation.Settings) (ConversationID, error) {
+	if err := s.limits.check(settings, s.provider); err != nil {
+		return "", err
+	}
+	options := append(append([]conversation.Option(nil), s.options...), conversation.WithSettings(settings))
+
 	cid := ConversationID(uuid.New().String())
 
-	s.conversations[cid] = conversation.New(s.client, s.db, s.dbType, s.schema)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	// Each conversation keeps the schema it was started with, so it is not
+	// affected by later reloads
+	options = append(options, conversation.WithSchemaTokenCounts(s.schemaTokens))
+	s.conversations[cid] = conversation.New(s.provider, s.db, s.dbType, s.schema, options...)
 	return cid, nil
 }
 
+func (s *conversationServer) conversation(cid ConversationID) (*conversation.Conversation, bool) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	conv, ok := s.conversations[cid]
+	return conv, ok
+}
+
+func (s *conversationServer) ReloadSchema() (int, error) {
+	if s.loadSchema == nil {
+		return 0, fmt.Errorf("schema reloading is not supported")
+	}
+
+	s.reloadMtx.Lock()
+	defer s.reloadMtx.Unlock()
+
+	// The schema is loaded without holding mtx, so conversations can
+	// continue while it loads
+	newSchema, err := s.loadSchema()
+	if err != nil {
+		return 0, fmt.Errorf("reloading schema: %w", err)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.schema = newSchema
+	s.schemaTokens = conversation.NewSchemaTokenCounts(newSchema)
+	log.Printf("Reloaded schema with %v tables", len(newSchema.Tables))
+	return len(newSchema.Tables), nil
+}
+
+// NewConversationRequest optionally includes the settings for the
+// conversation, such as {"model": "gpt-4", "temperature": 0}
 type NewConversationRequest struct {
+	conversation.Settings
 }
 
 type NewConversationResponse struct {
@@ -65,7 +200,8 @@ type NewConversationResponse struct {
 
 func makeNewConversationEndpoint(svc Server) endpoint.Endpoint {
 	return func(_ context.Context, request interface{}) (interface{}, error) {
-		v, err := svc.NewConversation()
+		req := request.(NewConversationRequest)
+		v, err := svc.NewConversation(req.Settings)
 		if err != nil {
 			return NewConversationResponse{
 				Err: err.Error(),
@@ -81,7 +217,12 @@ func GetNewConversationHandler(svc Server) *httptransport.Server {
 	return httptransport.NewServer(
 		makeNewConversationEndpoint(svc),
 		func(_ context.Context, r *http.Request) (interface{}, error) {
-			return NewConversationRequest{}, nil
+			// The settings are optional, so an empty body uses the defaults
+			var request NewConversationRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+				return nil, err
+			}
+			return request, nil
 		},
 		func(_ context.Context, w http.ResponseWriter, response interface{}) error {
 			return json.NewEncoder(w).Encode(response)
@@ -90,7 +231,7 @@ func GetNewConversationHandler(svc Server) *httptransport.Server {
 }
 
 func (s *conversationServer) SampleQuestions(cid ConversationID) ([]string, error) {
-	conv, ok := s.conversations[cid]
+	conv, ok := s.conversation(cid)
 	if !ok {
 		return nil, ErrConversationNotFound
 	}
@@ -98,7 +239,7 @@ func (s *conversationServer) SampleQuestions(cid ConversationID) ([]string, erro
 }
 
 func (s *conversationServer) Ask(cid ConversationID, question string) (*conversation.Response, error) {
-	conv, ok := s.conversations[cid]
+	conv, ok := s.conversation(cid)
 	if !ok {
 		return nil, ErrConversationNotFound
 	}
@@ -151,16 +292,22 @@ type AskRequest struct {
 }
 
 type AskResponse struct {
-	Query   string `json:"query"`
-	DataCsv string `json:"data_csv"`
-	Err     string `json:"err,omitempty"`
+	Query    string                 `json:"query"`
+	RawText  string                 `json:"raw_text"`
+	DataCsv  string                 `json:"data_csv"`
+	Attempts []conversation.Attempt `json:"attempts,omitempty"`
+	// Prompt describes the tokens used, and any earlier exchanges that were
+	// summarized or dropped to fit the model's context window
+	Prompt *conversation.PromptUsage `json:"prompt,omitempty"`
+	Err    string                    `json:"err,omitempty"`
 }
 
 func makeAskEndpoint(svc Server) endpoint.Endpoint {
 	return func(_ context.Context, request interface{}) (interface{}, error) {
 		req := request.(AskRequest)
 		v, err := svc.Ask(ConversationID(req.ConversationID), req.Question)
-		if err != nil {
+		// Failed queries are returned with the attempts that caused them
+		if err != nil && v == nil {
 			return AskResponse{
 				Err: err.Error(),
 			}, nil
@@ -170,9 +317,12 @@ func makeAskEndpoint(svc Server) endpoint.Endpoint {
 			errStr = v.Error.Error()
 		}
 		return AskResponse{
-			Query:   v.Query,
-			DataCsv: v.DataCsv,
-			Err:     errStr,
+			Query:    v.Query,
+			RawText:  v.RawText,
+			DataCsv:  v.DataCsv,
+			Attempts: v.Attempts,
+			Prompt:   v.Prompt,
+			Err:      errStr,
 		}, nil
 	}
 }
@@ -192,3 +342,223 @@ func GetAskHandler(svc Server) *httptransport.Server {
 		},
 	)
 }
+
+// TableInfo summarizes a table in the schema
+type TableInfo struct {
+	Name    string             `json:"name"`
+	Kind    string             `json:"kind"`
+	Comment string             `json:"comment,omitempty"`
+	Columns int                `json:"columns"`
+	Stats   *schema.TableStats `json:"stats,omitempty"`
+}
+
+func (s *conversationServer) Tables() ([]TableInfo, error) {
+	s.mtx.RLock()
+	current := s.schema
+	s.mtx.RUnlock()
+
+	var tables []TableInfo
+	for _, table := range current.Tables {
+		tables = append(tables, TableInfo{
+			Name:    table.QualifiedName(),
+			Kind:    string(table.Kind),
+			Comment: table.Comment,
+			Columns: len(table.Columns),
+			Stats:   table.Stats,
+		})
+	}
+	return tables, nil
+}
+
+type TablesRequest struct {
+}
+
+type TablesResponse struct {
+	Tables []TableInfo `json:"tables"`
+	Err    string      `json:"err,omitempty"`
+}
+
+func makeTablesEndpoint(svc Server) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		v, err := svc.Tables()
+		if err != nil {
+			return TablesResponse{
+				Err: err.Error(),
+			}, nil
+		}
+		return TablesResponse{
+			Tables: v,
+		}, nil
+	}
+}
+
+func GetTablesHandler(svc Server) *httptransport.Server {
+	return httptransport.NewServer(
+		makeTablesEndpoint(svc),
+		func(_ context.Context, r *http.Request) (interface{}, error) {
+			return TablesRequest{}, nil
+		},
+		func(_ context.Context, w http.ResponseWriter, response interface{}) error {
+			return json.NewEncoder(w).Encode(response)
+		},
+	)
+}
+
+func (s *conversationServer) ExportSchema(format string) (string, error) {
+	s.mtx.RLock()
+	current := s.schema
+	s.mtx.RUnlock()
+	return current.Export(format)
+}
+
+type ExportSchemaRequest struct {
+	Format string `json:"format"`
+}
+
+type ExportSchemaResponse struct {
+	Format  string `json:"format"`
+	Content string `json:"content"`
+	Err     string `json:"err,omitempty"`
+}
+
+func makeExportSchemaEndpoint(svc Server) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req := request.(ExportSchemaRequest)
+		v, err := svc.ExportSchema(req.Format)
+		if err != nil {
+			return ExportSchemaResponse{
+				Format: req.Format,
+				Err:    err.Error(),
+			}, nil
+		}
+		return ExportSchemaResponse{
+			Format:  req.Format,
+			Content: v,
+		}, nil
+	}
+}
+
+// GetExportSchemaHandler returns a handler serving the schema in the format
+// given by the format query parameter, defaulting to sql. The schema is
+// returned as the body of the response, rather than wrapped in JSON, so it
+// can be saved directly.
+func GetExportSchemaHandler(svc Server) *httptransport.Server {
+	return httptransport.NewServer(
+		makeExportSchemaEndpoint(svc),
+		func(_ context.Context, r *http.Request) (interface{}, error) {
+			format := r.URL.Query().Get("format")
+			if format == "" {
+				format = "sql"
+			}
+			return ExportSchemaRequest{Format: format}, nil
+		},
+		func(_ context.Context, w http.ResponseWriter, response interface{}) error {
+			resp := response.(ExportSchemaResponse)
+			if resp.Err != "" {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				return json.NewEncoder(w).Encode(resp)
+			}
+			if resp.Format == "json" {
+				w.Header().Set("Content-Type", "application/json")
+			} else {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			}
+			_, err := w.Write([]byte(resp.Content))
+			return err
+		},
+	)
+}
+
+type ReloadSchemaRequest struct {
+}
+
+type ReloadSchemaResponse struct {
+	Tables int    `json:"tables"`
+	Err    string `json:"err,omitempty"`
+}
+
+func makeReloadSchemaEndpoint(svc Server) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		v, err := svc.ReloadSchema()
+		if err != nil {
+			return ReloadSchemaResponse{
+				Err: err.Error(),
+			}, nil
+		}
+		return ReloadSchemaResponse{
+			Tables: v,
+		}, nil
+	}
+}
+
+// GetReloadSchemaHandler returns a handler reloading the schema on a POST
+// request with the header "Authorization: Bearer <adminToken>". If
+// adminToken is empty, every request is rejected.
+func GetReloadSchemaHandler(svc Server, adminToken string) *httptransport.Server {
+	return httptransport.NewServer(
+		makeReloadSchemaEndpoint(svc),
+		func(_ context.Context, r *http.Request) (interface{}, error) {
+			if r.Method != http.MethodPost {
+				return nil, httpError{
+					status:  http.StatusMethodNotAllowed,
+					message: "method not allowed, use POST",
+					headers: http.Header{"Allow": []string{http.MethodPost}},
+				}
+			}
+			if err := checkAdminToken(r, adminToken); err != nil {
+				return nil, err
+			}
+			return ReloadSchemaRequest{}, nil
+		},
+		func(_ context.Context, w http.ResponseWriter, response interface{}) error {
+			return json.NewEncoder(w).Encode(response)
+		},
+	)
+}
+
+// checkAdminToken returns an error unless the request is authorized with
+// the admin token
+func checkAdminToken(r *http.Request, adminToken string) error {
+	if adminToken == "" {
+		return httpError{
+			status:  http.StatusForbidden,
+			message: "admin endpoints are disabled, as no admin token is configured",
+		}
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		return httpError{
+			status:  http.StatusUnauthorized,
+			message: "invalid admin token",
+			headers: http.Header{"WWW-Authenticate": []string{"Bearer"}},
+		}
+	}
+	return nil
+}
+
+// httpError is an error returned before reaching an endpoint, such as when
+// a request is not authorized. It is encoded by go-kit's default error
+// encoder with its status code and headers, in the same JSON form as the
+// errors returned by endpoints.
+type httpError struct {
+	status  int
+	message string
+	headers http.Header
+}
+
+func (e httpError) Error() string {
+	return e.message
+}
+
+func (e httpError) StatusCode() int {
+	return e.status
+}
+
+func (e httpError) Headers() http.Header {
+	return e.headers
+}
+
+func (e httpError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"err": e.message})
+}
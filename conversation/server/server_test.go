@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/theothertomelliott/gptsql/conversation"
+)
+
+// stubProvider has a default model and temperature limit, but cannot
+// complete messages
+type stubProvider struct {
+	maxTemperature float32
+}
+
+func (p stubProvider) Complete(context.Context, conversation.CompletionRequest) ([]string, error) {
+	panic("not implemented")
+}
+
+func (p stubProvider) Model() string {
+	return "default"
+}
+
+func (p stubProvider) MaxTemperature() float32 {
+	return p.maxTemperature
+}
+
+func TestLimitsCheck(t *testing.T) {
+	limits := Limits{
+		Models:            []string{"allowed"},
+		MaxCandidates:     5,
+		MaxTokens:         2048,
+		MaxRepairAttempts: 3,
+	}
+	temperature := func(t float32) *float32 { return &t }
+	tests := []struct {
+		name           string
+		settings       conversation.Settings
+		maxTemperature float32
+		wantErr        bool
+	}{
+		{
+			name:           "defaults",
+			maxTemperature: 2,
+		},
+		{
+			name:           "default model",
+			settings:       conversation.Settings{Model: "default"},
+			maxTemperature: 2,
+		},
+		{
+			name:           "allowed model",
+			settings:       conversation.Settings{Model: "allowed"},
+			maxTemperature: 2,
+		},
+		{
+			name:           "other model",
+			settings:       conversation.Settings{Model: "other"},
+			maxTemperature: 2,
+			wantErr:        true,
+		},
+		{
+			name:           "temperature within the provider's limit",
+			settings:       conversation.Settings{Temperature: temperature(1.5)},
+			maxTemperature: 2,
+		},
+		{
+			name:           "temperature above the provider's limit",
+			settings:       conversation.Settings{Temperature: temperature(1.5)},
+			maxTemperature: 1,
+			wantErr:        true,
+		},
+		{
+			name:           "negative temperature",
+			settings:       conversation.Settings{Temperature: temperature(-0.5)},
+			maxTemperature: 2,
+			wantErr:        true,
+		},
+		{
+			name:           "maximum settings",
+			settings:       conversation.Settings{Candidates: 5, MaxTokens: 2048, RepairAttempts: 3},
+			maxTemperature: 2,
+		},
+		{
+			name:           "too many candidates",
+			settings:       conversation.Settings{Candidates: 6},
+			maxTemperature: 2,
+			wantErr:        true,
+		},
+		{
+			name:           "too many tokens",
+			settings:       conversation.Settings{MaxTokens: 2049},
+			maxTemperature: 2,
+			wantErr:        true,
+		},
+		{
+			name:           "too many repair attempts",
+			settings:       conversation.Settings{RepairAttempts: 4},
+			maxTemperature: 2,
+			wantErr:        true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := limits.check(test.settings, stubProvider{maxTemperature: test.maxTemperature})
+			if (err != nil) != test.wantErr {
+				t.Errorf("got error %v, expected an error %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -2,22 +2,31 @@ package main
 
 import (
 	"database/sql"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
+	_ "github.com/marcboeker/go-duckdb"
+	_ "github.com/mattn/go-sqlite3"
 	"github.com/sashabaranov/go-openai"
 	sf "github.com/snowflakedb/gosnowflake"
+	"github.com/theothertomelliott/gptsql/conversation"
 	"github.com/theothertomelliott/gptsql/conversation/server"
 	"github.com/theothertomelliott/gptsql/schema"
 )
 
 func main() {
-	var dsn, dbType string
+	var dsn, dbType, duckDBDir string
 	var useDevFrontEnd bool
 	var err error
 
@@ -30,6 +39,24 @@ func main() {
 		dbType = "postgres"
 	}
 
+	if os.Getenv("MYSQL_CONN_STRING") != "" {
+		dsn = os.Getenv("MYSQL_CONN_STRING")
+		dbType = "mysql"
+	}
+
+	if os.Getenv("SQLITE_PATH") != "" {
+		dsn = os.Getenv("SQLITE_PATH")
+		dbType = "sqlite3"
+	}
+
+	if os.Getenv("DUCKDB_PATH") != "" {
+		dsn, duckDBDir, err = getDuckDBDSN(os.Getenv("DUCKDB_PATH"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		dbType = "duckdb"
+	}
+
 	if os.Getenv("SNOWFLAKE_ACCOUNT") != "" {
 		dsn, _, err = getSnowflakeDSN()
 		if err != nil {
@@ -38,23 +65,177 @@ func main() {
 		dbType = "snowflake"
 	}
 
-	if dsn == "" {
+	// Without a connection, queries can be generated from a schema loaded
+	// from DDL, but not run
+	ddlPath := os.Getenv("SCHEMA_DDL_PATH")
+	if ddlPath != "" {
+		if dbType != "" {
+			log.Fatal("SCHEMA_DDL_PATH cannot be used with a database connection.")
+		}
+		dbType = os.Getenv("SCHEMA_DDL_DIALECT")
+		if dbType == "" {
+			dbType = "postgres"
+		}
+	}
+
+	if dbType == "" {
 		log.Fatal("No database connection config was provided.")
 	}
 
-	db, err := sql.Open(dbType, dsn)
+	var db *sql.DB
+	if ddlPath == "" {
+		db, err = sql.Open(dbType, dsn)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if duckDBDir != "" {
+		if err := createDuckDBFileViews(db, duckDBDir); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var schemaOptions []schema.Option
+	if schemas := envList("POSTGRES_SCHEMAS"); dbType == "postgres" && len(schemas) > 0 {
+		schemaOptions = append(schemaOptions, schema.WithSchemas(schemas...))
+	}
+	if os.Getenv("SCHEMA_VIEW_DEFINITIONS") != "" {
+		schemaOptions = append(schemaOptions, schema.WithViewDefinitions())
+	}
+	if os.Getenv("SCHEMA_LOAD_CONCURRENCY") != "" {
+		concurrency, err := strconv.Atoi(os.Getenv("SCHEMA_LOAD_CONCURRENCY"))
+		if err != nil {
+			log.Fatalf("parsing SCHEMA_LOAD_CONCURRENCY: %v", err)
+		}
+		schemaOptions = append(schemaOptions, schema.WithConcurrency(concurrency))
+	}
+	if os.Getenv("SCHEMA_SAMPLE_ROWS") != "" {
+		rows, err := strconv.Atoi(os.Getenv("SCHEMA_SAMPLE_ROWS"))
+		if err != nil {
+			log.Fatalf("parsing SCHEMA_SAMPLE_ROWS: %v", err)
+		}
+		schemaOptions = append(schemaOptions, schema.WithSampleRows(rows))
+	}
+	if os.Getenv("SCHEMA_PROFILE_VALUES") != "" {
+		maxValues, err := strconv.Atoi(os.Getenv("SCHEMA_PROFILE_VALUES"))
+		if err != nil {
+			log.Fatalf("parsing SCHEMA_PROFILE_VALUES: %v", err)
+		}
+		schemaOptions = append(schemaOptions, schema.WithValueProfiles(maxValues))
+	}
+	if os.Getenv("SCHEMA_SAMPLE_TIMEOUT") != "" {
+		timeout, err := time.ParseDuration(os.Getenv("SCHEMA_SAMPLE_TIMEOUT"))
+		if err != nil {
+			log.Fatalf("parsing SCHEMA_SAMPLE_TIMEOUT: %v", err)
+		}
+		schemaOptions = append(schemaOptions, schema.WithSampleTimeout(timeout))
+	}
+
+	if dbType == "snowflake" && os.Getenv("SNOWFLAKE_SCHEMA") != "" {
+		schemaOptions = append(schemaOptions, schema.WithSchemas(snowflakeSchema()))
+	}
+	includeTables := envList("SCHEMA_INCLUDE_TABLES")
+	excludeTables := envList("SCHEMA_EXCLUDE_TABLES")
+	if len(includeTables) > 0 || len(excludeTables) > 0 {
+		schemaOptions = append(schemaOptions, schema.WithTableFilter(includeTables, excludeTables))
+	}
+	includeColumns, excludeColumns := envList("SCHEMA_INCLUDE_COLUMNS"), envList("SCHEMA_EXCLUDE_COLUMNS")
+	if len(includeColumns) > 0 || len(excludeColumns) > 0 {
+		schemaOptions = append(schemaOptions, schema.WithColumnFilter(includeColumns, excludeColumns))
+	}
+
+	if os.Getenv("SCHEMA_REDACT_COLUMNS") != "" {
+		var rules []schema.RedactionRule
+		for _, rule := range envList("SCHEMA_REDACT_COLUMNS") {
+			columns, policyName, found := strings.Cut(rule, "=")
+			policy := schema.RedactMask
+			if found {
+				policy, err = schema.ParseRedactionPolicy(policyName)
+				if err != nil {
+					log.Fatalf("parsing SCHEMA_REDACT_COLUMNS: %v", err)
+				}
+			}
+			rules = append(rules, schema.RedactionRule{Columns: strings.TrimSpace(columns), Policy: policy})
+		}
+		schemaOptions = append(schemaOptions, schema.WithRedactionRules(rules...))
+	}
+	if os.Getenv("SCHEMA_REDACT_DETECTED") != "" {
+		policy, err := schema.ParseRedactionPolicy(os.Getenv("SCHEMA_REDACT_DETECTED"))
+		if err != nil {
+			log.Fatalf("parsing SCHEMA_REDACT_DETECTED: %v", err)
+		}
+		schemaOptions = append(schemaOptions, schema.WithDetectedDataPolicy(policy))
+	}
+
+	if os.Getenv("SCHEMA_OVERLAY_PATH") != "" {
+		schemaOptions = append(schemaOptions, schema.WithOverlay(os.Getenv("SCHEMA_OVERLAY_PATH")))
+	}
+
+	if os.Getenv("SCHEMA_CACHE_DIR") != "" {
+		schemaOptions = append(schemaOptions, schema.WithCache(os.Getenv("SCHEMA_CACHE_DIR"), dsn+duckDBDir))
+	}
+	if os.Getenv("SCHEMA_CACHE_MAX_AGE") != "" {
+		maxAge, err := time.ParseDuration(os.Getenv("SCHEMA_CACHE_MAX_AGE"))
+		if err != nil {
+			log.Fatalf("parsing SCHEMA_CACHE_MAX_AGE: %v", err)
+		}
+		schemaOptions = append(schemaOptions, schema.WithCacheMaxAge(maxAge))
+	}
+
+	loadSchema := func() (schema.Schema, error) {
+		if ddlPath != "" {
+			return schema.LoadDDL(dbType, ddlPath, schemaOptions...)
+		}
+		return schema.Load(dbType, db, schemaOptions...)
+	}
+	schema, err := loadSchema()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	schema, err := schema.Load(dbType, db)
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := exportSchema(schema, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	provider, err := newProvider()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	client := openai.NewClient(os.Getenv("OPENAI_API_TOKEN"))
+	var conversationOptions []conversation.Option
+	if os.Getenv("LLM_CONTEXT_WINDOW") != "" {
+		tokens, err := strconv.Atoi(os.Getenv("LLM_CONTEXT_WINDOW"))
+		if err != nil {
+			log.Fatalf("parsing LLM_CONTEXT_WINDOW: %v", err)
+		}
+		conversationOptions = append(conversationOptions, conversation.WithContextWindow(tokens))
+	}
+	if os.Getenv("SCHEMA_PROMPT_TOKENS") != "" {
+		maxTokens, err := strconv.Atoi(os.Getenv("SCHEMA_PROMPT_TOKENS"))
+		if err != nil {
+			log.Fatalf("parsing SCHEMA_PROMPT_TOKENS: %v", err)
+		}
+		conversationOptions = append(conversationOptions, conversation.WithSchemaTokens(maxTokens))
+	}
+	settings, limits, err := llmSettings()
+	if err != nil {
+		log.Fatal(err)
+	}
+	conversationOptions = append(conversationOptions, conversation.WithSettings(settings))
 
-	svr := server.New(client, db, dbType, schema)
+	svr := server.New(provider, limits, db, dbType, schema, loadSchema, conversationOptions...)
+
+	if os.Getenv("SCHEMA_RELOAD_INTERVAL") != "" {
+		interval, err := time.ParseDuration(os.Getenv("SCHEMA_RELOAD_INTERVAL"))
+		if err != nil {
+			log.Fatalf("parsing SCHEMA_RELOAD_INTERVAL: %v", err)
+		}
+		go reloadSchemaPeriodically(svr, interval)
+	}
 
 	mux := http.NewServeMux()
 
@@ -67,6 +248,15 @@ func main() {
 	sampleQuestionsHandler := server.GetSampleQuestionsHandler(svr)
 	mux.Handle("/sample-questions", sampleQuestionsHandler)
 
+	exportSchemaHandler := server.GetExportSchemaHandler(svr)
+	mux.Handle("/schema", exportSchemaHandler)
+
+	tablesHandler := server.GetTablesHandler(svr)
+	mux.Handle("/tables", tablesHandler)
+
+	reloadSchemaHandler := server.GetReloadSchemaHandler(svr, os.Getenv("ADMIN_TOKEN"))
+	mux.Handle("/admin/reload-schema", reloadSchemaHandler)
+
 	if useDevFrontEnd {
 		remote, err := url.Parse("http://localhost:3000")
 		if err != nil {
@@ -86,6 +276,138 @@ func main() {
 	}
 }
 
+// exportSchema writes the schema in the format given by the -format flag to
+// stdout, or the file given by the -o flag.
+func exportSchema(s schema.Schema, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "sql", fmt.Sprintf("export format (%v)", strings.Join(schema.ExportFormats, ", ")))
+	output := flags.String("o", "", "file to write to, defaults to stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	content, err := s.Export(*format)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		fmt.Println(content)
+		return nil
+	}
+	if err := os.WriteFile(*output, []byte(content), 0o644); err != nil {
+		return fmt.Errorf("writing schema: %w", err)
+	}
+	return nil
+}
+
+// newProvider returns the language model provider selected by LLM_PROVIDER
+func newProvider() (conversation.Provider, error) {
+	model := os.Getenv("LLM_MODEL")
+	switch os.Getenv("LLM_PROVIDER") {
+	case "", "openai":
+		if model == "" {
+			model = openai.GPT3Dot5Turbo
+		}
+		return conversation.NewOpenAIProvider(os.Getenv("OPENAI_API_TOKEN"), os.Getenv("OPENAI_BASE_URL"), model), nil
+	case "openai-compatible":
+		if os.Getenv("OPENAI_BASE_URL") == "" {
+			return nil, fmt.Errorf("OPENAI_BASE_URL is required for the openai-compatible provider")
+		}
+		if model == "" {
+			return nil, fmt.Errorf("LLM_MODEL is required for the openai-compatible provider")
+		}
+		return conversation.NewOpenAIProvider(os.Getenv("OPENAI_API_TOKEN"), os.Getenv("OPENAI_BASE_URL"), model), nil
+	case "anthropic":
+		if model == "" {
+			return nil, fmt.Errorf("LLM_MODEL is required for the anthropic provider")
+		}
+		return conversation.NewAnthropicProvider(os.Getenv("ANTHROPIC_API_KEY"), os.Getenv("ANTHROPIC_BASE_URL"), model), nil
+	default:
+		return nil, fmt.Errorf("unknown LLM_PROVIDER %q, expected openai, openai-compatible or anthropic", os.Getenv("LLM_PROVIDER"))
+	}
+}
+
+// llmSettings returns the default settings for conversations, and the limits
+// on the settings that may be requested for each conversation
+func llmSettings() (conversation.Settings, server.Limits, error) {
+	var settings conversation.Settings
+	limits := server.DefaultLimits
+	limits.Models = envList("LLM_ALLOWED_MODELS")
+
+	if os.Getenv("LLM_TEMPERATURE") != "" {
+		temperature, err := strconv.ParseFloat(os.Getenv("LLM_TEMPERATURE"), 32)
+		if err != nil {
+			return settings, limits, fmt.Errorf("parsing LLM_TEMPERATURE: %w", err)
+		}
+		t := float32(temperature)
+		settings.Temperature = &t
+	}
+	for name, value := range map[string]*int{
+		"LLM_CANDIDATES":            &settings.Candidates,
+		"LLM_MAX_TOKENS":            &settings.MaxTokens,
+		"LLM_LIMIT_CANDIDATES":      &limits.MaxCandidates,
+		"LLM_LIMIT_MAX_TOKENS":      &limits.MaxTokens,
+		"LLM_REPAIR_ATTEMPTS":       &settings.RepairAttempts,
+		"LLM_LIMIT_REPAIR_ATTEMPTS": &limits.MaxRepairAttempts,
+	} {
+		if os.Getenv(name) == "" {
+			continue
+		}
+		n, err := strconv.Atoi(os.Getenv(name))
+		if err != nil {
+			return settings, limits, fmt.Errorf("parsing %v: %w", name, err)
+		}
+		*value = n
+	}
+
+	if settings.Candidates > limits.MaxCandidates {
+		return settings, limits, fmt.Errorf("LLM_CANDIDATES of %v exceeds LLM_LIMIT_CANDIDATES of %v", settings.Candidates, limits.MaxCandidates)
+	}
+	if settings.MaxTokens > limits.MaxTokens {
+		return settings, limits, fmt.Errorf("LLM_MAX_TOKENS of %v exceeds LLM_LIMIT_MAX_TOKENS of %v", settings.MaxTokens, limits.MaxTokens)
+	}
+	if settings.RepairAttempts > limits.MaxRepairAttempts {
+		return settings, limits, fmt.Errorf("LLM_REPAIR_ATTEMPTS of %v exceeds LLM_LIMIT_REPAIR_ATTEMPTS of %v", settings.RepairAttempts, limits.MaxRepairAttempts)
+	}
+	return settings, limits, nil
+}
+
+// reloadSchemaPeriodically reloads the schema used for new conversations at
+// the given interval. Errors are logged and the previous schema kept.
+func reloadSchemaPeriodically(svr server.Server, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := svr.ReloadSchema(); err != nil {
+			log.Printf("Could not reload schema: %v", err)
+		}
+	}
+}
+
+// envList returns the comma-separated values of an environment variable
+func envList(name string) []string {
+	if os.Getenv(name) == "" {
+		return nil
+	}
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// snowflakeSchema returns the configured Snowflake schema, qualified by
+// the configured database if any. Without a schema, tables are listed from
+// the database used by the connection.
+func snowflakeSchema() string {
+	if database := os.Getenv("SNOWFLAKE_DATABASE"); database != "" {
+		return database + "." + os.Getenv("SNOWFLAKE_SCHEMA")
+	}
+	return os.Getenv("SNOWFLAKE_SCHEMA")
+}
+
 // getSnowflakeDSN constructs a DSN based on the test connection parameters
 func getSnowflakeDSN() (string, *sf.Config, error) {
 	cfg := &sf.Config{
@@ -104,3 +426,55 @@ func getSnowflakeDSN() (string, *sf.Config, error) {
 	dsn, err := sf.DSN(cfg)
 	return dsn, cfg, err
 }
+
+// getDuckDBDSN returns the DSN to open a DuckDB database at the given path.
+// If the path is a directory, an in-memory database is used and the directory
+// is returned so its files can be exposed as views.
+func getDuckDBDSN(path string) (string, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", "", fmt.Errorf("opening duckdb path: %w", err)
+	}
+	if info.IsDir() {
+		return "", path, nil
+	}
+	return path, "", nil
+}
+
+// createDuckDBFileViews creates a view for each Parquet and CSV file in dir,
+// named after the file.
+func createDuckDBFileViews(db *sql.DB, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("reading data directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		var reader string
+		ext := filepath.Ext(entry.Name())
+		switch strings.ToLower(ext) {
+		case ".parquet":
+			reader = "read_parquet"
+		case ".csv":
+			reader = "read_csv_auto"
+		default:
+			continue
+		}
+
+		name := strings.TrimSuffix(entry.Name(), ext)
+		path := filepath.Join(dir, entry.Name())
+		_, err := db.Exec(fmt.Sprintf(
+			`CREATE VIEW "%v" AS SELECT * FROM %v('%v');`,
+			strings.ReplaceAll(name, `"`, `""`),
+			reader,
+			strings.ReplaceAll(path, "'", "''"),
+		))
+		if err != nil {
+			return fmt.Errorf("creating view for %v: %w", path, err)
+		}
+	}
+	return nil
+}
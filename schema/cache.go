@@ -0,0 +1,80 @@
+package schema
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheEntry is the content of a schema cache file
+type cacheEntry struct {
+	Fingerprint string    `json:"fingerprint"`
+	CachedAt    time.Time `json:"cached_at"`
+	Schema      Schema    `json:"schema"`
+}
+
+// cachePath returns the path of the cache file for a database. The options
+// that affect the content of the schema are included in the file name, so
+// that changing them does not return a stale schema. The name is hashed to
+// avoid writing credentials from the key to disk.
+func (o options) cachePath(dbType string) string {
+	hash := sha256.New()
+	fmt.Fprintf(
+		hash, "%q\n%q\n%q\n%v\n%v\n%v\n%q\n%q\n%q\n%q\n%q\n%q",
+		dbType, o.cacheKey, o.schemas, o.viewDefinitions, o.sampleRows, o.profileValues,
+		o.includeTables, o.excludeTables, o.includeColumns, o.excludeColumns,
+		o.redactionRules, o.detectedPolicy,
+	)
+	return filepath.Join(o.cacheDir, hex.EncodeToString(hash.Sum(nil))+".json")
+}
+
+// readCache returns the schema cached at path, if it exists, matches the
+// given fingerprint and was cached within maxAge.
+func readCache(path string, fingerprint string, maxAge time.Duration) (Schema, bool) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Schema{}, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(content, &entry); err != nil {
+		return Schema{}, false
+	}
+	if entry.Fingerprint != fingerprint {
+		return Schema{}, false
+	}
+	// Stats, sample rows and profiles are not covered by the fingerprint,
+	// so are refreshed by reloading the schema once the cache is too old
+	if age := time.Since(entry.CachedAt); age > maxAge {
+		log.Printf("Not using schema cache, cached %v ago", age.Round(time.Second))
+		return Schema{}, false
+	}
+	return entry.Schema, true
+}
+
+// writeCache caches a schema and its fingerprint at path
+func writeCache(path string, fingerprint string, schema Schema) error {
+	content, err := json.Marshal(cacheEntry{
+		Fingerprint: fingerprint,
+		CachedAt:    time.Now(),
+		Schema:      schema,
+	})
+	if err != nil {
+		return fmt.Errorf("encoding schema: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("creating cache directory: %w", err)
+	}
+
+	// Write to a temporary file first, so a partially written cache file is
+	// never read
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o600); err != nil {
+		return fmt.Errorf("writing cache: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
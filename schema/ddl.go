@@ -0,0 +1,580 @@
+package schema
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// LoadDDL loads a schema from a file of SQL statements, for databases that
+// cannot be connected to. See ParseDDL for the statements supported.
+func LoadDDL(dbType string, path string, opts ...Option) (Schema, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Schema{}, fmt.Errorf("reading DDL: %w", err)
+	}
+	schema, err := ParseDDL(dbType, string(content), opts...)
+	if err != nil {
+		return Schema{}, fmt.Errorf("loading schema from %v: %w", path, err)
+	}
+	log.Printf("Parsed %v tables from %v", len(schema.Tables), path)
+	return schema, nil
+}
+
+// ParseDDL builds a schema from SQL statements written in the dialect of the
+// given database type, such as the output of pg_dump --schema-only or
+// mysqldump --no-data. The following statements are used, and any others
+// ignored:
+//
+//   - CREATE TABLE, with column and table constraints and comments
+//   - CREATE VIEW and CREATE MATERIALIZED VIEW
+//   - CREATE TYPE ... AS ENUM
+//   - ALTER TABLE ... ADD, for constraints and columns
+//   - COMMENT ON TABLE and COMMENT ON COLUMN
+//   - INSERT INTO ... VALUES, for sample rows
+//
+// SQL comments on the lines directly before a CREATE statement are used as
+// the table comment, and comments on the same line as a column as the column
+// comment. The table, column and redaction options apply as they do for Load,
+// and WithSampleRows limits the rows taken from INSERT statements. The
+// definitions of views are always kept where their columns are not listed, as
+// they are the only description of the view.
+func ParseDDL(dbType string, ddl string, opts ...Option) (Schema, error) {
+	o := newOptions(opts)
+	dialect, ok := dialectNames[dbType]
+	if !ok {
+		return Schema{}, fmt.Errorf("unsupported database type %v", dbType)
+	}
+	tableFilter, columnFilter, redactor, err := o.filters()
+	if err != nil {
+		return Schema{}, err
+	}
+
+	tokens, err := tokenizeDDL(ddl, syntaxFor(dbType))
+	if err != nil {
+		return Schema{}, err
+	}
+	s := &ddlSchema{
+		dbType: dbType,
+		enums:  make(map[string][]string),
+		rows:   make(map[string][]map[string]Value),
+	}
+	for _, statement := range splitDDLStatements(tokens) {
+		p := &ddlParser{src: ddl, tokens: statement}
+		if err := s.statement(p); err != nil {
+			return Schema{}, fmt.Errorf("line %v: %w", p.line(), err)
+		}
+	}
+	s.resolveEnums()
+	s.resolveReferences()
+
+	tables := filterTables(s.tables, tableFilter, columnFilter)
+	for i := range tables {
+		table := &tables[i]
+		if !o.viewDefinitions && len(table.Columns) > 0 {
+			table.Definition = ""
+		}
+		for _, values := range s.rows[strings.ToLower(table.QualifiedName())] {
+			if len(table.SampleRows) >= o.sampleRows {
+				break
+			}
+			var row []Value
+			for _, column := range table.Columns {
+				value, ok := values[strings.ToLower(column.Name)]
+				if !ok {
+					value = Value{Null: true}
+				}
+				row = append(row, value)
+			}
+			table.SampleRows = append(table.SampleRows, row)
+		}
+	}
+	if redacted := redactor.redactTables(tables); redacted > 0 {
+		log.Printf("Redacted %v sample values", redacted)
+	}
+
+	return o.applyOverlay(Schema{
+		Dialect: dialect,
+		Tables:  tables,
+	})
+}
+
+// ddlSchema accumulates the tables described by DDL statements
+type ddlSchema struct {
+	dbType string
+	tables []Table
+	// enums maps the lower case names of enum types to their labels
+	enums map[string][]string
+	// rows maps lower case qualified table names to the values of rows
+	// inserted into them, keyed by lower case column name
+	rows map[string][]map[string]Value
+}
+
+// statement applies a single statement to the schema
+func (s *ddlSchema) statement(p *ddlParser) error {
+	comment := p.leadingComment()
+	switch {
+	case p.accept("CREATE"):
+		p.accept("OR", "REPLACE")
+		for p.acceptAny("TEMP", "TEMPORARY", "TRANSIENT", "VOLATILE", "UNLOGGED", "GLOBAL", "LOCAL", "SECURE", "RECURSIVE") {
+		}
+		switch {
+		case p.accept("TABLE"):
+			return s.createTable(p, comment)
+		case p.accept("VIEW"):
+			return s.createView(p, View, comment)
+		case p.accept("MATERIALIZED", "VIEW"):
+			return s.createView(p, MaterializedView, comment)
+		case p.accept("TYPE"):
+			return s.createType(p)
+		}
+	case p.accept("ALTER", "TABLE"):
+		return s.alterTable(p)
+	case p.accept("COMMENT", "ON"):
+		return s.commentOn(p)
+	case p.accept("INSERT", "INTO"):
+		return s.insert(p)
+	}
+	return nil
+}
+
+func (s *ddlSchema) createTable(p *ddlParser, comment string) error {
+	p.accept("IF", "NOT", "EXISTS")
+	parts, err := p.name()
+	if err != nil {
+		return err
+	}
+	// Tables created from a query or another table have no columns to load
+	if !p.accept("(") {
+		return nil
+	}
+
+	table := newDDLTable(parts, BaseTable)
+	// SQLite's internal tables are listed by .schema, but are not loaded
+	// from a database
+	if s.dbType == "sqlite3" && strings.HasPrefix(strings.ToLower(table.Name), "sqlite_") {
+		return nil
+	}
+	table.Comment = comment
+	var leading string
+	lastLine, lastColumn := 0, -1
+	for {
+		// Comments on the same line as the previous definition describe it,
+		// others describe the next definition
+		for _, c := range p.comments() {
+			if c.line == lastLine && lastColumn >= 0 && table.Columns[lastColumn].Comment == "" {
+				table.Columns[lastColumn].Comment = c.value
+			} else if c.line != lastLine {
+				leading = joinComments(leading, c.value)
+			}
+		}
+		if p.peek() == nil {
+			return fmt.Errorf("unterminated CREATE TABLE %v", table.QualifiedName())
+		}
+		if p.accept(")") {
+			break
+		}
+
+		tokens, comments := p.group()
+		if len(tokens) == 0 {
+			return fmt.Errorf("empty definition in CREATE TABLE %v", table.QualifiedName())
+		}
+		lastLine, lastColumn = tokens[len(tokens)-1].line, -1
+		def := &ddlParser{tokens: tokens}
+		if !def.tableConstraint(&table) {
+			column := def.column(&table)
+			for _, c := range comments {
+				column.Comment = joinComments(column.Comment, c.value)
+			}
+			if column.Comment == "" {
+				column.Comment = leading
+			}
+			table.Columns = append(table.Columns, column)
+			lastColumn = len(table.Columns) - 1
+		}
+		leading = ""
+		if p.accept(",") {
+			lastLine = p.tokens[p.pos-1].line
+		}
+	}
+
+	// Table options, such as MySQL's COMMENT='...' or Snowflake's
+	// COMMENT = '...'
+	for p.peek() != nil {
+		if p.accept("COMMENT") {
+			p.accept("=")
+			if t := p.next(); t != nil && t.kind == ddlString {
+				table.Comment = t.value
+			}
+			continue
+		}
+		p.skip()
+	}
+
+	s.tables = append(s.tables, table)
+	return nil
+}
+
+func (s *ddlSchema) createView(p *ddlParser, kind TableKind, comment string) error {
+	p.accept("IF", "NOT", "EXISTS")
+	parts, err := p.name()
+	if err != nil {
+		return err
+	}
+	table := newDDLTable(parts, kind)
+	table.Comment = comment
+	if p.at("(") {
+		for _, name := range p.identifierList() {
+			table.Columns = append(table.Columns, Column{Name: name})
+		}
+	}
+	for p.peek() != nil {
+		switch {
+		case p.accept("COMMENT"):
+			p.accept("=")
+			if t := p.next(); t != nil && t.kind == ddlString {
+				table.Comment = t.value
+			}
+		case p.accept("AS"):
+			// Trailing comments, such as those added by SQLite's .schema
+			// command, are not part of the query
+			end := len(p.tokens) - 1
+			for end > p.pos && p.tokens[end].kind == ddlComment {
+				end--
+			}
+			if start := p.peek(); start != nil {
+				table.Definition = strings.TrimSpace(p.src[start.start:p.tokens[end].end])
+			}
+			s.tables = append(s.tables, table)
+			return nil
+		default:
+			p.skip()
+		}
+	}
+	return fmt.Errorf("CREATE VIEW %v has no query", table.QualifiedName())
+}
+
+// createType records the labels of enum types, such as those created by
+// CREATE TYPE mood AS ENUM ('sad', 'ok', 'happy')
+func (s *ddlSchema) createType(p *ddlParser) error {
+	parts, err := p.name()
+	if err != nil {
+		return err
+	}
+	if !p.accept("AS", "ENUM") || !p.accept("(") {
+		return nil
+	}
+	var labels []string
+	for {
+		if t := p.next(); t != nil && t.kind == ddlString {
+			labels = append(labels, t.value)
+		}
+		if !p.accept(",") {
+			break
+		}
+	}
+	s.enums[strings.ToLower(strings.Join(parts, "."))] = labels
+	return nil
+}
+
+func (s *ddlSchema) alterTable(p *ddlParser) error {
+	p.accept("IF", "EXISTS")
+	p.accept("ONLY")
+	parts, err := p.name()
+	if err != nil {
+		return err
+	}
+	table := s.find(parts)
+	if table == nil {
+		return nil
+	}
+	for p.accept("ADD") {
+		tokens, _ := p.group()
+		def := &ddlParser{tokens: tokens}
+		if !def.tableConstraint(table) {
+			def.accept("COLUMN")
+			def.accept("IF", "NOT", "EXISTS")
+			if def.peek() != nil {
+				table.Columns = append(table.Columns, def.column(table))
+			}
+		}
+		if !p.accept(",") {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *ddlSchema) commentOn(p *ddlParser) error {
+	column := p.accept("COLUMN")
+	if !column && !p.accept("TABLE") && !p.accept("VIEW") && !p.accept("MATERIALIZED", "VIEW") {
+		return nil
+	}
+	parts, err := p.name()
+	if err != nil {
+		return err
+	}
+	if !p.accept("IS") {
+		return nil
+	}
+	t := p.next()
+	if t == nil || t.kind != ddlString {
+		return nil
+	}
+
+	if !column {
+		if table := s.find(parts); table != nil {
+			table.Comment = t.value
+		}
+		return nil
+	}
+	if len(parts) < 2 {
+		return nil
+	}
+	table := s.find(parts[:len(parts)-1])
+	if table == nil {
+		return nil
+	}
+	for i := range table.Columns {
+		if strings.EqualFold(table.Columns[i].Name, parts[len(parts)-1]) {
+			table.Columns[i].Comment = t.value
+		}
+	}
+	return nil
+}
+
+func (s *ddlSchema) insert(p *ddlParser) error {
+	parts, err := p.name()
+	if err != nil {
+		return err
+	}
+	table := s.find(parts)
+	if table == nil {
+		return nil
+	}
+
+	var columns []string
+	if p.at("(") {
+		columns = p.identifierList()
+	} else {
+		for _, column := range table.Columns {
+			columns = append(columns, column.Name)
+		}
+	}
+	if !p.accept("VALUES") {
+		return nil
+	}
+
+	key := strings.ToLower(table.QualifiedName())
+	for p.accept("(") {
+		values := make(map[string]Value)
+		for i := 0; ; i++ {
+			tokens, _ := p.group()
+			if i < len(columns) {
+				values[strings.ToLower(columns[i])] = ddlValue(tokens)
+			}
+			if !p.accept(",") {
+				break
+			}
+		}
+		p.accept(")")
+		s.rows[key] = append(s.rows[key], values)
+		if !p.accept(",") {
+			break
+		}
+	}
+	return nil
+}
+
+// find returns the table with the given qualified name. Names match if one is
+// a suffix of the other, so unqualified references match qualified tables.
+func (s *ddlSchema) find(parts []string) *Table {
+	name := strings.ToLower(strings.Join(parts, "."))
+	for i := range s.tables {
+		qualified := strings.ToLower(s.tables[i].QualifiedName())
+		if qualified == name || strings.HasSuffix(qualified, "."+name) || strings.HasSuffix(name, "."+qualified) {
+			return &s.tables[i]
+		}
+	}
+	return nil
+}
+
+// resolveReferences names the tables referenced by foreign keys as they are
+// named by the tables themselves, so that unqualified references match
+// qualified tables. References to tables not in the DDL are left as written.
+func (s *ddlSchema) resolveReferences() {
+	for t := range s.tables {
+		for i := range s.tables[t].ForeignKeys {
+			foreignKey := &s.tables[t].ForeignKeys[i]
+			if table := s.find(strings.Split(foreignKey.ReferencedTable, ".")); table != nil {
+				foreignKey.ReferencedTable = table.QualifiedName()
+			}
+		}
+	}
+}
+
+// resolveEnums lists the labels of columns using enum types created with
+// CREATE TYPE
+func (s *ddlSchema) resolveEnums() {
+	if len(s.enums) == 0 {
+		return
+	}
+	for t := range s.tables {
+		for c := range s.tables[t].Columns {
+			column := &s.tables[t].Columns[c]
+			typeName := strings.ToLower(strings.TrimSuffix(column.Type, "[]"))
+			labels, ok := s.enums[typeName]
+			if !ok {
+				// Types may be referenced with or without their schema
+				for name, l := range s.enums {
+					if strings.HasSuffix(name, "."+typeName) || strings.HasSuffix(typeName, "."+name) {
+						labels, ok = l, true
+						break
+					}
+				}
+			}
+			if ok && len(column.EnumValues) == 0 {
+				column.EnumValues = labels
+			}
+		}
+	}
+}
+
+// newDDLTable returns a table with a name made up of the given parts
+func newDDLTable(parts []string, kind TableKind) Table {
+	table := Table{Kind: kind, Name: parts[len(parts)-1]}
+	if len(parts) > 1 {
+		table.Schema = parts[len(parts)-2]
+	}
+	if len(parts) > 2 {
+		table.Database = parts[len(parts)-3]
+	}
+	return table
+}
+
+// tableConstraint applies a primary or foreign key constraint to the table,
+// returning false if the definition is not a table constraint
+func (p *ddlParser) tableConstraint(table *Table) bool {
+	if p.accept("CONSTRAINT") {
+		p.next()
+	}
+	switch {
+	case p.accept("PRIMARY", "KEY"):
+		table.PrimaryKey = p.identifierList()
+		return true
+	case p.accept("FOREIGN", "KEY"):
+		columns := p.identifierList()
+		if p.accept("REFERENCES") {
+			foreignKey := p.references()
+			foreignKey.Columns = columns
+			table.ForeignKeys = append(table.ForeignKeys, foreignKey)
+		}
+		return true
+	case p.acceptAny("UNIQUE", "CHECK", "EXCLUDE", "FULLTEXT", "SPATIAL"):
+		return true
+	case p.at("KEY") || p.at("INDEX"):
+		return p.isIndex()
+	}
+	return false
+}
+
+// isIndex returns true if the definition starting with KEY or INDEX is a
+// MySQL index, such as KEY idx_name (name), rather than a column named key
+// or index
+func (p *ddlParser) isIndex() bool {
+	rest := p.tokens[p.pos+1:]
+	if len(rest) > 1 && rest[0].kind != ddlPunct && rest[1].is("(") {
+		rest = rest[1:]
+	}
+	if len(rest) == 0 || !rest[0].is("(") {
+		return false
+	}
+	for _, t := range rest {
+		if t.kind == ddlNumber {
+			return false
+		}
+	}
+	return true
+}
+
+// columnConstraints are the keywords that end the type of a column
+var columnConstraints = []string{
+	"CONSTRAINT", "NOT", "NULL", "PRIMARY", "UNIQUE", "REFERENCES", "DEFAULT", "CHECK",
+	"COLLATE", "GENERATED", "AUTO_INCREMENT", "AUTOINCREMENT", "IDENTITY", "COMMENT", "AS", "ON",
+}
+
+func (p *ddlParser) atColumnConstraint() bool {
+	return p.atAny(columnConstraints...) || p.at("CHARACTER", "SET")
+}
+
+// column parses a column definition, applying any key constraints to table
+func (p *ddlParser) column(table *Table) Column {
+	column := Column{Name: identifier(p.next())}
+	typeTokens := p.until(p.atColumnConstraint)
+	column.Type = joinTokens(typeTokens)
+	if len(typeTokens) > 0 && (typeTokens[0].is("ENUM") || typeTokens[0].is("SET")) {
+		for _, t := range typeTokens {
+			if t.kind == ddlString {
+				column.EnumValues = append(column.EnumValues, t.value)
+			}
+		}
+	}
+
+	for p.peek() != nil {
+		switch {
+		case p.accept("NOT", "NULL"):
+			column.NotNull = true
+		case p.accept("PRIMARY", "KEY"):
+			table.PrimaryKey = []string{column.Name}
+		case p.accept("REFERENCES"):
+			foreignKey := p.references()
+			foreignKey.Columns = []string{column.Name}
+			table.ForeignKeys = append(table.ForeignKeys, foreignKey)
+		case p.accept("DEFAULT"):
+			column.Default = joinTokens(p.until(p.atColumnConstraint))
+		case p.accept("COMMENT"):
+			if t := p.next(); t != nil && t.kind == ddlString {
+				column.Comment = t.value
+			}
+		default:
+			p.skip()
+		}
+	}
+	return column
+}
+
+// references parses the table and columns referenced by a foreign key
+func (p *ddlParser) references() ForeignKey {
+	parts, _ := p.name()
+	foreignKey := ForeignKey{ReferencedTable: strings.Join(parts, ".")}
+	if p.at("(") {
+		foreignKey.ReferencedColumns = p.identifierList()
+	}
+	return foreignKey
+}
+
+// ddlValue returns the value of an expression in an INSERT statement
+func ddlValue(tokens []ddlToken) Value {
+	if len(tokens) == 1 {
+		t := tokens[0]
+		switch {
+		case t.kind == ddlString:
+			return Value{Text: t.value}
+		case t.kind == ddlNumber:
+			return Value{Text: t.raw, Unquoted: true}
+		case t.is("NULL"):
+			return Value{Null: true}
+		case t.is("TRUE") || t.is("FALSE"):
+			return Value{Text: strings.ToUpper(t.raw), Unquoted: true}
+		}
+	}
+	return Value{Text: joinTokens(tokens), Unquoted: true}
+}
+
+func joinComments(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "\n" + b
+}
@@ -0,0 +1,467 @@
+package schema
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseDDLDumps(t *testing.T) {
+	tests := []struct {
+		file   string
+		dbType string
+		want   []Table
+	}{
+		{
+			file:   "pg_dump.sql",
+			dbType: "postgres",
+			want: []Table{
+				{
+					Schema:  "public",
+					Name:    "customers",
+					Kind:    BaseTable,
+					Comment: "People who have placed orders",
+					Columns: []Column{
+						{Name: "id", Type: "integer", NotNull: true},
+						{Name: "Full Name", Type: "character varying(200)", NotNull: true, Comment: "Name as given at sign up, it's not verified"},
+						{Name: "current_mood", Type: "public.mood", Default: "'ok'::public.mood", EnumValues: []string{"sad", "ok", "happy"}},
+						{Name: "created_at", Type: "timestamp with time zone", NotNull: true, Default: "now()"},
+					},
+					PrimaryKey: []string{"id"},
+				},
+				{
+					Schema: "public",
+					Name:   "orders",
+					Kind:   BaseTable,
+					Columns: []Column{
+						{Name: "id", Type: "bigint", NotNull: true},
+						{Name: "customer_id", Type: "integer", NotNull: true},
+						{Name: "total", Type: "numeric(10, 2)"},
+						{Name: "note", Type: "text", Default: "$tag$it's; fine$tag$", Comment: "Left by the customer, it's\nprinted on the receipt"},
+					},
+					PrimaryKey: []string{"id"},
+					ForeignKeys: []ForeignKey{
+						{Columns: []string{"customer_id"}, ReferencedTable: "public.customers", ReferencedColumns: []string{"id"}},
+					},
+				},
+				{
+					Schema:     "public",
+					Name:       "order_totals",
+					Kind:       View,
+					Definition: "SELECT orders.customer_id,\n    sum(orders.total) AS total\n   FROM public.orders\n  GROUP BY orders.customer_id",
+				},
+			},
+		},
+		{
+			file:   "mysqldump.sql",
+			dbType: "mysql",
+			want: []Table{
+				{
+					Name:    "customers",
+					Kind:    BaseTable,
+					Comment: "Registered customers",
+					Columns: []Column{
+						{Name: "id", Type: "int", NotNull: true},
+						{Name: "email", Type: "varchar(255)", NotNull: true, Comment: "Login address"},
+						{Name: "status", Type: "enum('active', 'closed')", NotNull: true, Default: "'active'", EnumValues: []string{"active", "closed"}},
+						{Name: "key", Type: "varchar(20)"},
+					},
+					PrimaryKey: []string{"id"},
+					SampleRows: [][]Value{
+						{{Text: "1", Unquoted: true}, {Text: "a@example.com"}, {Text: "active"}, {Text: "it's"}},
+						{{Text: "2", Unquoted: true}, {Text: "b@example.com"}, {Text: "closed"}, {Null: true}},
+					},
+				},
+				{
+					Name: "order items",
+					Kind: BaseTable,
+					Columns: []Column{
+						{Name: "order_id", Type: "int", NotNull: true},
+						{Name: "line", Type: "int", NotNull: true},
+						{Name: "customer_id", Type: "int"},
+						{Name: "price", Type: "decimal(10, 2) unsigned", NotNull: true},
+					},
+					PrimaryKey: []string{"order_id", "line"},
+					ForeignKeys: []ForeignKey{
+						{Columns: []string{"customer_id"}, ReferencedTable: "customers", ReferencedColumns: []string{"id"}},
+					},
+				},
+			},
+		},
+		{
+			file:   "sqlite_schema.sql",
+			dbType: "sqlite3",
+			want: []Table{
+				{
+					Name: "artists",
+					Kind: BaseTable,
+					Columns: []Column{
+						{Name: "id", Type: "INTEGER"},
+						{Name: "name", Type: "TEXT", NotNull: true, Comment: "as credited"},
+					},
+					PrimaryKey: []string{"id"},
+				},
+				{
+					Name: "albums",
+					Kind: BaseTable,
+					Columns: []Column{
+						{Name: "id", Type: "INTEGER"},
+						{Name: "artist_id", Type: "INTEGER", NotNull: true},
+						{Name: "title", Type: "TEXT"},
+						{Name: "released", Type: "DATE", Default: "(date('now'))"},
+					},
+					PrimaryKey: []string{"id"},
+					ForeignKeys: []ForeignKey{
+						{Columns: []string{"artist_id"}, ReferencedTable: "artists"},
+					},
+				},
+				{
+					Name: "tracks",
+					Kind: BaseTable,
+					Columns: []Column{
+						{Name: "album_id", Type: "INTEGER"},
+						{Name: "number", Type: "INTEGER"},
+						{Name: "title", Type: "TEXT"},
+					},
+					PrimaryKey: []string{"album_id", "number"},
+					ForeignKeys: []ForeignKey{
+						{Columns: []string{"album_id"}, ReferencedTable: "albums", ReferencedColumns: []string{"id"}},
+					},
+				},
+				{
+					Name:       "album_lengths",
+					Kind:       View,
+					Definition: "SELECT album_id, count(*) AS tracks FROM tracks GROUP BY album_id",
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			ddl, err := os.ReadFile(filepath.Join("testdata", test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := ParseDDL(test.dbType, string(ddl), WithSampleRows(5), WithDetectedDataPolicy(RedactKeep))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(s.Tables) != len(test.want) {
+				t.Fatalf("expected %v tables, got %v: %+v", len(test.want), len(s.Tables), s.Tables)
+			}
+			for i, want := range test.want {
+				if got := s.Tables[i]; !reflect.DeepEqual(got, want) {
+					t.Errorf("table %v:\ngot  %+v\nwant %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseDDLStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		dbType string
+		ddl    string
+		want   Table
+	}{
+		{
+			name:   "inline foreign key",
+			dbType: "postgres",
+			ddl:    `CREATE TABLE orders (id int PRIMARY KEY, customer_id int NOT NULL REFERENCES customers (id) ON DELETE CASCADE);`,
+			want: Table{
+				Name:        "orders",
+				Kind:        BaseTable,
+				Columns:     []Column{{Name: "id", Type: "int"}, {Name: "customer_id", Type: "int", NotNull: true}},
+				PrimaryKey:  []string{"id"},
+				ForeignKeys: []ForeignKey{{Columns: []string{"customer_id"}, ReferencedTable: "customers", ReferencedColumns: []string{"id"}}},
+			},
+		},
+		{
+			name:   "table level foreign key",
+			dbType: "postgres",
+			ddl:    `CREATE TABLE lines (order_id int, n int, CONSTRAINT lines_order_fk FOREIGN KEY (order_id, n) REFERENCES "Sales"."Orders" ("Id", "N"));`,
+			want: Table{
+				Name:        "lines",
+				Kind:        BaseTable,
+				Columns:     []Column{{Name: "order_id", Type: "int"}, {Name: "n", Type: "int"}},
+				ForeignKeys: []ForeignKey{{Columns: []string{"order_id", "n"}, ReferencedTable: "Sales.Orders", ReferencedColumns: []string{"Id", "N"}}},
+			},
+		},
+		{
+			name:   "alter table add column and constraint",
+			dbType: "postgres",
+			ddl: `CREATE TABLE t (id int);
+				ALTER TABLE ONLY t ADD COLUMN IF NOT EXISTS parent_id int, ADD CONSTRAINT t_pkey PRIMARY KEY (id);
+				ALTER TABLE t ADD CONSTRAINT t_parent_fkey FOREIGN KEY (parent_id) REFERENCES t(id);`,
+			want: Table{
+				Name:        "t",
+				Kind:        BaseTable,
+				Columns:     []Column{{Name: "id", Type: "int"}, {Name: "parent_id", Type: "int"}},
+				PrimaryKey:  []string{"id"},
+				ForeignKeys: []ForeignKey{{Columns: []string{"parent_id"}, ReferencedTable: "t", ReferencedColumns: []string{"id"}}},
+			},
+		},
+		{
+			name:   "comments on view and columns",
+			dbType: "postgres",
+			ddl: `CREATE VIEW v (a, "B") AS SELECT 1, 2;
+				COMMENT ON VIEW v IS 'A view';
+				COMMENT ON COLUMN v."B" IS 'The second';
+				COMMENT ON COLUMN missing.a IS 'Ignored';`,
+			want: Table{
+				Name:    "v",
+				Kind:    View,
+				Comment: "A view",
+				Columns: []Column{{Name: "a"}, {Name: "B", Comment: "The second"}},
+			},
+		},
+		{
+			name:   "leading and trailing comments",
+			dbType: "postgres",
+			ddl: `-- Accounts of users
+				CREATE TABLE accounts (
+					-- Unique identifier
+					id int,
+					balance numeric /* in cents */
+				);`,
+			want: Table{
+				Name:    "accounts",
+				Kind:    BaseTable,
+				Comment: "Accounts of users",
+				Columns: []Column{{Name: "id", Type: "int", Comment: "Unique identifier"}, {Name: "balance", Type: "numeric", Comment: "in cents"}},
+			},
+		},
+		{
+			name:   "quoted identifiers",
+			dbType: "postgres",
+			ddl:    `CREATE TABLE "My ""Table""" ("Column; One" text, "select" int);`,
+			want: Table{
+				Name:    `My "Table"`,
+				Kind:    BaseTable,
+				Columns: []Column{{Name: "Column; One", Type: "text"}, {Name: "select", Type: "int"}},
+			},
+		},
+		{
+			name:   "mysql backticks and escapes",
+			dbType: "mysql",
+			ddl:    "CREATE TABLE `a``b` (`c` varchar(10) COMMENT 'it\\'s \\\\ here', KEY `k` (`c`));",
+			want: Table{
+				Name:    "a`b",
+				Kind:    BaseTable,
+				Columns: []Column{{Name: "c", Type: "varchar(10)", Comment: `it's \ here`}},
+			},
+		},
+		{
+			name:   "enum array type",
+			dbType: "postgres",
+			ddl: `CREATE TYPE app.status AS ENUM ('new', 'done');
+				CREATE TABLE tasks (history status[]);`,
+			want: Table{
+				Name:    "tasks",
+				Kind:    BaseTable,
+				Columns: []Column{{Name: "history", Type: "status[]", EnumValues: []string{"new", "done"}}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := ParseDDL(test.dbType, test.ddl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(s.Tables) != 1 {
+				t.Fatalf("expected 1 table, got %+v", s.Tables)
+			}
+			if got := s.Tables[0]; !reflect.DeepEqual(got, test.want) {
+				t.Errorf("\ngot  %+v\nwant %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseDDLReferences(t *testing.T) {
+	tests := []struct {
+		name string
+		ddl  string
+		want string
+	}{
+		{
+			name: "unqualified reference to qualified table",
+			ddl: `CREATE TABLE public.customers (id int PRIMARY KEY);
+				CREATE TABLE public.orders (id int, customer_id int REFERENCES customers(id));`,
+			want: "public.customers",
+		},
+		{
+			name: "reference in a different case",
+			ddl: `CREATE TABLE "Customers" (id int PRIMARY KEY);
+				CREATE TABLE orders (id int, customer_id int REFERENCES public."Customers"(id));`,
+			want: "Customers",
+		},
+		{
+			name: "reference to a later table",
+			ddl: `CREATE TABLE orders (id int, customer_id int);
+				CREATE TABLE sales.customers (id int PRIMARY KEY);
+				ALTER TABLE orders ADD FOREIGN KEY (customer_id) REFERENCES customers (id);`,
+			want: "sales.customers",
+		},
+		{
+			name: "reference to a missing table",
+			ddl:  `CREATE TABLE orders (id int, customer_id int REFERENCES Sales.Customers(id));`,
+			want: "Sales.Customers",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := ParseDDL("postgres", test.ddl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var foreignKeys []ForeignKey
+			for _, table := range s.Tables {
+				if table.Name == "orders" {
+					foreignKeys = table.ForeignKeys
+				}
+			}
+			if len(foreignKeys) != 1 || foreignKeys[0].ReferencedTable != test.want {
+				t.Errorf("expected a reference to %v, got %+v", test.want, foreignKeys)
+			}
+		})
+	}
+}
+
+func TestParseDDLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		ddl  string
+	}{
+		{name: "unterminated string", ddl: "CREATE TABLE t (a text DEFAULT 'x);"},
+		{name: "unterminated dollar quote", ddl: "CREATE FUNCTION f() AS $$ SELECT 1;"},
+		{name: "unterminated identifier", ddl: `CREATE TABLE "t (a int);`},
+		{name: "unterminated comment", ddl: "CREATE TABLE t (a int); /* trailing"},
+		{name: "unterminated table", ddl: "CREATE TABLE t (a int"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ParseDDL("postgres", test.ddl); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestTokenizeDDL(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		syntax ddlSyntax
+		want   []ddlToken
+	}{
+		{
+			name: "quoted identifiers and strings",
+			src:  `"a""b" 'c''d'`,
+			want: []ddlToken{
+				{kind: ddlQuotedIdentifier, raw: `"a""b"`, value: `a"b`},
+				{kind: ddlString, raw: `'c''d'`, value: "c'd"},
+			},
+		},
+		{
+			name: "dollar quoted strings",
+			src:  "$$a;'b'$$ $fn$ $$ ; $fn$",
+			want: []ddlToken{
+				{kind: ddlString, raw: "$$a;'b'$$", value: "a;'b'"},
+				{kind: ddlString, raw: "$fn$ $$ ; $fn$", value: " $$ ; "},
+			},
+		},
+		{
+			name:   "backslash escapes",
+			src:    `'a\'b\n' ` + "`c`",
+			syntax: ddlSyntax{backslashEscapes: true},
+			want: []ddlToken{
+				{kind: ddlString, raw: `'a\'b\n'`, value: "a'b\n"},
+				{kind: ddlQuotedIdentifier, raw: "`c`", value: "c"},
+			},
+		},
+		{
+			name:   "escape strings",
+			src:    `E'a\'b\n' e'\\' 'c\' E`,
+			syntax: ddlSyntax{escapeStrings: true},
+			want: []ddlToken{
+				{kind: ddlString, raw: `E'a\'b\n'`, value: "a'b\n"},
+				{kind: ddlString, raw: `e'\\'`, value: `\`},
+				{kind: ddlString, raw: `'c\'`, value: `c\`},
+				{kind: ddlWord, raw: "E"},
+			},
+		},
+		{
+			name:   "bracket identifiers",
+			src:    "[my table] int[]",
+			syntax: ddlSyntax{bracketIdentifiers: true},
+			want: []ddlToken{
+				{kind: ddlQuotedIdentifier, raw: "[my table]", value: "my table"},
+				{kind: ddlWord, raw: "int"},
+				{kind: ddlPunct, raw: "["},
+				{kind: ddlPunct, raw: "]"},
+			},
+		},
+		{
+			name: "array types without bracket identifiers",
+			src:  "int[]",
+			want: []ddlToken{
+				{kind: ddlWord, raw: "int"},
+				{kind: ddlPunct, raw: "["},
+				{kind: ddlPunct, raw: "]"},
+			},
+		},
+		{
+			name: "comments, numbers and casts",
+			src:  "-- note\n1.5e3::numeric /* multi\nline */",
+			want: []ddlToken{
+				{kind: ddlComment, raw: "-- note", value: "note"},
+				{kind: ddlNumber, raw: "1.5e3"},
+				{kind: ddlPunct, raw: "::"},
+				{kind: ddlWord, raw: "numeric"},
+				{kind: ddlComment, raw: "/* multi\nline */", value: "multi\nline"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokens, err := tokenizeDDL(test.src, test.syntax)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []ddlToken
+			for _, token := range tokens {
+				got = append(got, ddlToken{kind: token.kind, raw: token.raw, value: token.value})
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("\ngot  %+v\nwant %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSplitDDLStatements(t *testing.T) {
+	src := "CREATE TABLE a (x text DEFAULT ';');\nCREATE FUNCTION f() AS $$ SELECT 1; $$;\n-- ; in a comment\nCREATE TABLE b (y int)"
+	tokens, err := tokenizeDDL(src, ddlSyntax{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, statement := range splitDDLStatements(tokens) {
+		var words []string
+		for _, token := range statement {
+			if token.kind == ddlWord {
+				words = append(words, token.raw)
+			}
+		}
+		if len(words) >= 3 {
+			got = append(got, words[2])
+		}
+	}
+	if want := []string{"a", "f", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got statements for %v, want %v", got, want)
+	}
+}
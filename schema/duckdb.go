@@ -0,0 +1,222 @@
+package schema
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"github.com/marcboeker/go-duckdb"
+)
+
+type duckdbLoader struct{}
+
+func (d *duckdbLoader) loadTables(db *sql.DB) ([]Table, error) {
+	tables, err := d.tableList(db)
+	if err != nil {
+		return nil, err
+	}
+	index := indexTables(tables)
+
+	if err := d.loadColumns(db, tables, index); err != nil {
+		return nil, err
+	}
+	if err := d.loadKeys(db, tables, index); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+// tableList returns the tables and views in the current schema, with the
+// estimated number of rows in each table. Views are included so that files
+// exposed as views (such as Parquet or CSV files) can be queried.
+func (d *duckdbLoader) tableList(db *sql.DB) ([]Table, error) {
+	var tables []Table
+	rows, err := db.Query(`
+		SELECT table_name, false, COALESCE(comment, ''), '', estimated_size
+		FROM duckdb_tables()
+		WHERE database_name = current_database() AND schema_name = current_schema() AND NOT internal
+		UNION ALL
+		SELECT view_name, true, COALESCE(comment, ''), sql, NULL
+		FROM duckdb_views()
+		WHERE database_name = current_database() AND schema_name = current_schema() AND NOT internal
+		ORDER BY 1`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("listing tables: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table Table
+		var isView bool
+		var createStatement string
+		var rowCount sql.NullInt64
+		if err := rows.Scan(&table.Name, &isView, &table.Comment, &createStatement, &rowCount); err != nil {
+			return nil, fmt.Errorf("scanning tables: %w", err)
+		}
+		table.Stats = newTableStats(rowCount, sql.NullInt64{}, sql.NullTime{})
+		table.Kind = BaseTable
+		if isView {
+			table.Kind = View
+			table.Definition = viewQuery(createStatement)
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+// loadColumns loads the columns for all tables
+func (d *duckdbLoader) loadColumns(db *sql.DB, tables []Table, index tableIndex) error {
+	rows, err := db.Query(`
+		SELECT table_name, column_name, data_type, NOT is_nullable, COALESCE(column_default, ''), COALESCE(comment, '')
+		FROM duckdb_columns()
+		WHERE database_name = current_database() AND schema_name = current_schema() AND NOT internal
+		ORDER BY table_name, column_index`,
+	)
+	if err != nil {
+		return fmt.Errorf("describing tables: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tableName string
+		var column Column
+		err := rows.Scan(&tableName, &column.Name, &column.Type, &column.NotNull, &column.Default, &column.Comment)
+		if err != nil {
+			return fmt.Errorf("scanning columns: %w", err)
+		}
+		if table := index.find(tables, Table{Name: tableName}); table != nil {
+			table.Columns = append(table.Columns, column)
+		}
+	}
+	return rows.Err()
+}
+
+// duckdbReferencePattern matches the referenced table and columns in the
+// text of a foreign key constraint
+var duckdbReferencePattern = regexp.MustCompile(`REFERENCES\s+(.+?)\s*\((.*)\)\s*$`)
+
+// loadKeys loads the primary key columns and foreign keys for all tables
+func (d *duckdbLoader) loadKeys(db *sql.DB, tables []Table, index tableIndex) error {
+	rows, err := db.Query(`
+		SELECT table_name, constraint_type, constraint_text, constraint_column_names
+		FROM duckdb_constraints()
+		WHERE database_name = current_database() AND schema_name = current_schema()
+			AND constraint_type IN ('PRIMARY KEY', 'FOREIGN KEY')
+		ORDER BY table_name, constraint_index`,
+	)
+	if err != nil {
+		return fmt.Errorf("loading keys: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tableName, constraintType, constraintText string
+		var columnNames interface{}
+		if err := rows.Scan(&tableName, &constraintType, &constraintText, &columnNames); err != nil {
+			return fmt.Errorf("scanning keys: %w", err)
+		}
+		table := index.find(tables, Table{Name: tableName})
+		if table == nil {
+			continue
+		}
+
+		var columns []string
+		if list, ok := columnNames.([]interface{}); ok {
+			for _, column := range list {
+				columns = append(columns, fmt.Sprint(column))
+			}
+		}
+
+		if constraintType == "PRIMARY KEY" {
+			table.PrimaryKey = columns
+			continue
+		}
+
+		// The referenced table is only available in the constraint text
+		match := duckdbReferencePattern.FindStringSubmatch(constraintText)
+		if match == nil {
+			continue
+		}
+		foreignKey := ForeignKey{
+			Columns:         columns,
+			ReferencedTable: match[1],
+		}
+		for _, column := range strings.Split(match[2], ",") {
+			foreignKey.ReferencedColumns = append(foreignKey.ReferencedColumns, strings.TrimSpace(column))
+		}
+		table.ForeignKeys = append(table.ForeignKeys, foreignKey)
+	}
+	return rows.Err()
+}
+
+// sampleQueries samples rows from 1% of a table, falling back to the first
+// rows for small tables
+func (d *duckdbLoader) sampleQueries(table Table, rows int) []string {
+	return []string{
+		fmt.Sprintf("SELECT * FROM %v TABLESAMPLE 1%% LIMIT %d;", d.quotedName(table), rows),
+		limitQuery(d.quotedName(table), rows),
+	}
+}
+
+func (d *duckdbLoader) quotedName(table Table) string {
+	return quoteIdentifier(table.Name)
+}
+
+func (d *duckdbLoader) quote(identifier string) string {
+	return quoteIdentifier(identifier)
+}
+
+// fingerprint hashes the definitions of all columns, along with the number
+// of key constraints
+func (d *duckdbLoader) fingerprint(db *sql.DB) (string, error) {
+	var columnCount, keyCount int64
+	var checksum, viewChecksum string
+	// The data type of enum columns includes their labels, so changes to
+	// enums are covered by the columns
+	err := db.QueryRow(`
+		SELECT
+			COUNT(*),
+			COALESCE(hash(string_agg(
+				concat_ws(':', table_name, column_name, data_type, is_nullable, column_default, comment),
+				',' ORDER BY table_name, column_index
+			)), 0),
+			(
+				SELECT COUNT(*) FROM duckdb_constraints()
+				WHERE database_name = current_database() AND schema_name = current_schema()
+					AND constraint_type IN ('PRIMARY KEY', 'FOREIGN KEY')
+			),
+			(
+				SELECT COALESCE(hash(string_agg(concat_ws(':', view_name, sql), ',' ORDER BY view_name)), 0)
+				FROM duckdb_views()
+				WHERE database_name = current_database() AND schema_name = current_schema() AND NOT internal
+			)
+		FROM duckdb_columns()
+		WHERE database_name = current_database() AND schema_name = current_schema() AND NOT internal`,
+	).Scan(&columnCount, &checksum, &keyCount, &viewChecksum)
+	if err != nil {
+		return "", fmt.Errorf("fingerprinting schema: %w", err)
+	}
+	return fmt.Sprintf("%v:%v:%v:%v", columnCount, checksum, keyCount, viewChecksum), nil
+}
+
+// duckdbDecimalText formats a DECIMAL value without losing precision
+func duckdbDecimalText(d duckdb.Decimal) string {
+	if d.Value == nil {
+		return "0"
+	}
+	digits := new(big.Int).Abs(d.Value).String()
+	scale := int(d.Scale)
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	text := digits
+	if scale > 0 {
+		text = digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+	}
+	if d.Value.Sign() < 0 {
+		text = "-" + text
+	}
+	return text
+}
@@ -0,0 +1,266 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ExportFormats lists the formats supported by Export
+var ExportFormats = []string{"sql", "json", "dbml", "mermaid"}
+
+// Export returns the schema in the given format:
+//
+//   - sql: the CREATE statements provided to the model
+//   - json: the full schema, including sample rows and profiles
+//   - dbml: a DBML project, as used by dbdiagram.io and dbdocs.io
+//   - mermaid: a Mermaid entity relationship diagram
+func (s Schema) Export(format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "sql":
+		return s.String(), nil
+	case "json":
+		return s.JSON()
+	case "dbml":
+		return s.DBML(), nil
+	case "mermaid":
+		return s.Mermaid(), nil
+	default:
+		return "", fmt.Errorf("unknown export format %q, expected one of %v", format, strings.Join(ExportFormats, ", "))
+	}
+}
+
+// JSON returns the schema as indented JSON
+func (s Schema) JSON() (string, error) {
+	out, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("encoding schema: %w", err)
+	}
+	return string(out), nil
+}
+
+// DBML returns the schema as DBML, with a Ref for each foreign key
+func (s Schema) DBML() string {
+	var out strings.Builder
+	for _, table := range s.Tables {
+		fmt.Fprintf(&out, "Table %v {\n", dbmlTableName(table.QualifiedName()))
+		singlePrimaryKey := len(table.PrimaryKey) == 1
+		for _, column := range table.Columns {
+			var settings []string
+			if singlePrimaryKey && table.PrimaryKey[0] == column.Name {
+				settings = append(settings, "pk")
+			}
+			if column.NotNull {
+				settings = append(settings, "not null")
+			}
+			if column.Default != "" {
+				settings = append(settings, "default: `"+strings.ReplaceAll(column.Default, "`", "'")+"`")
+			}
+			if note := column.annotation(); note != "" {
+				settings = append(settings, "note: "+dbmlString(note))
+			}
+			fmt.Fprintf(&out, "  %v %v", dbmlIdentifier(column.Name), dbmlType(column.Type))
+			if len(settings) > 0 {
+				fmt.Fprintf(&out, " [%v]", strings.Join(settings, ", "))
+			}
+			out.WriteString("\n")
+		}
+		if len(table.PrimaryKey) > 1 {
+			fmt.Fprintf(&out, "\n  indexes {\n    (%v) [pk]\n  }\n", dbmlIdentifiers(table.PrimaryKey))
+		}
+
+		var notes []string
+		if table.IsView() {
+			notes = append(notes, string(table.Kind))
+		}
+		if table.Comment != "" {
+			notes = append(notes, table.Comment)
+		}
+		if len(table.Synonyms) > 0 {
+			notes = append(notes, "also called: "+strings.Join(table.Synonyms, ", "))
+		}
+		if table.Stats != nil {
+			if stats := table.Stats.String(); stats != "" {
+				notes = append(notes, stats)
+			}
+		}
+		if len(notes) > 0 {
+			fmt.Fprintf(&out, "\n  Note: %v\n", dbmlString(strings.Join(notes, "\n")))
+		}
+		out.WriteString("}\n\n")
+	}
+
+	exported := s.tableNames()
+	for _, table := range s.Tables {
+		for _, foreignKey := range table.ForeignKeys {
+			// References to tables that are not exported would be invalid
+			if !exported[foreignKey.ReferencedTable] {
+				continue
+			}
+			referencedColumns := s.referencedColumns(foreignKey)
+			if len(referencedColumns) != len(foreignKey.Columns) {
+				continue
+			}
+			fmt.Fprintf(
+				&out, "Ref: %v.%v > %v.%v\n",
+				dbmlTableName(table.QualifiedName()), dbmlColumns(foreignKey.Columns),
+				dbmlTableName(foreignKey.ReferencedTable), dbmlColumns(referencedColumns),
+			)
+		}
+	}
+	return strings.TrimRight(out.String(), "\n") + "\n"
+}
+
+// tableNames returns the qualified names of the tables in the schema
+func (s Schema) tableNames() map[string]bool {
+	names := make(map[string]bool)
+	for _, table := range s.Tables {
+		names[table.QualifiedName()] = true
+	}
+	return names
+}
+
+// referencedColumns returns the columns referenced by a foreign key. Where
+// these are not known, such as for SQLite foreign keys that implicitly
+// reference a primary key, the primary key of the referenced table is used.
+func (s Schema) referencedColumns(foreignKey ForeignKey) []string {
+	if len(foreignKey.ReferencedColumns) > 0 {
+		return foreignKey.ReferencedColumns
+	}
+	for _, table := range s.Tables {
+		if table.QualifiedName() == foreignKey.ReferencedTable {
+			return table.PrimaryKey
+		}
+	}
+	return nil
+}
+
+// dbmlTableName returns a qualified table name in DBML syntax. DBML only
+// supports schema-qualified names, so any database is kept as part of the
+// schema name, as in "sales.public".orders, so that tables with the same
+// name in different databases remain distinct.
+func dbmlTableName(name string) string {
+	parts := strings.Split(name, ".")
+	if len(parts) > 2 {
+		parts = []string{strings.Join(parts[:len(parts)-1], "."), parts[len(parts)-1]}
+	}
+	var quoted []string
+	for _, part := range parts {
+		quoted = append(quoted, dbmlIdentifier(part))
+	}
+	return strings.Join(quoted, ".")
+}
+
+func dbmlColumns(columns []string) string {
+	if len(columns) == 1 {
+		return dbmlIdentifier(columns[0])
+	}
+	return "(" + dbmlIdentifiers(columns) + ")"
+}
+
+func dbmlIdentifiers(names []string) string {
+	var quoted []string
+	for _, name := range names {
+		quoted = append(quoted, dbmlIdentifier(name))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// plainIdentifier matches identifiers that can be used without quotes
+var plainIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func dbmlIdentifier(name string) string {
+	if plainIdentifier.MatchString(name) {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `\"`) + `"`
+}
+
+// plainType matches types that can be used without quotes, such as
+// varchar(255) or int[]
+var plainType = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\([0-9, ]*\))?(\[\])?$`)
+
+// dbmlType quotes types containing spaces or punctuation, such as
+// "timestamp with time zone"
+func dbmlType(columnType string) string {
+	if plainType.MatchString(columnType) {
+		return columnType
+	}
+	return `"` + strings.ReplaceAll(columnType, `"`, `\"`) + `"`
+}
+
+func dbmlString(text string) string {
+	text = strings.ReplaceAll(text, `\`, `\\`)
+	if strings.Contains(text, "\n") {
+		return "'''" + strings.ReplaceAll(text, "'''", `\'''`) + "'''"
+	}
+	return "'" + strings.ReplaceAll(text, "'", `\'`) + "'"
+}
+
+// Mermaid returns the schema as a Mermaid erDiagram, with a relationship for
+// each foreign key
+func (s Schema) Mermaid() string {
+	var out strings.Builder
+	out.WriteString("erDiagram\n")
+	for _, table := range s.Tables {
+		fmt.Fprintf(&out, "    %v {\n", mermaidName(table.QualifiedName()))
+
+		foreignKeyColumns := make(map[string]bool)
+		for _, foreignKey := range table.ForeignKeys {
+			for _, column := range foreignKey.Columns {
+				foreignKeyColumns[column] = true
+			}
+		}
+		for _, column := range table.Columns {
+			var keys []string
+			for _, key := range table.PrimaryKey {
+				if key == column.Name {
+					keys = append(keys, "PK")
+				}
+			}
+			if foreignKeyColumns[column.Name] {
+				keys = append(keys, "FK")
+			}
+			fmt.Fprintf(&out, "        %v %v", mermaidName(column.Type), mermaidName(column.Name))
+			if len(keys) > 0 {
+				fmt.Fprintf(&out, " %v", strings.Join(keys, ", "))
+			}
+			if column.Comment != "" {
+				fmt.Fprintf(&out, ` "%v"`, strings.ReplaceAll(singleLine(column.Comment), `"`, "'"))
+			}
+			out.WriteString("\n")
+		}
+		out.WriteString("    }\n")
+	}
+
+	exported := s.tableNames()
+	for _, table := range s.Tables {
+		for _, foreignKey := range table.ForeignKeys {
+			if !exported[foreignKey.ReferencedTable] {
+				continue
+			}
+			fmt.Fprintf(
+				&out, "    %v }o--|| %v : \"%v\"\n",
+				mermaidName(table.QualifiedName()),
+				mermaidName(foreignKey.ReferencedTable),
+				strings.Join(foreignKey.Columns, ", "),
+			)
+		}
+	}
+	return out.String()
+}
+
+// mermaidUnsupported matches characters that cannot be used in Mermaid
+// entity names, attribute names or types
+var mermaidUnsupported = regexp.MustCompile(`[^A-Za-z0-9_\-\[\]]+`)
+
+// mermaidName replaces unsupported characters, including the dots in
+// qualified names, with underscores
+func mermaidName(name string) string {
+	name = strings.Trim(mermaidUnsupported.ReplaceAllString(name, "_"), "_")
+	if name == "" {
+		return "unknown"
+	}
+	return name
+}
@@ -0,0 +1,114 @@
+package schema
+
+import "testing"
+
+// exportSchema has a composite primary key, a view in another database, and
+// a foreign key referencing a table that is not in the schema
+var exportSchema = Schema{
+	Tables: []Table{
+		{
+			Schema:  "public",
+			Name:    "customers",
+			Kind:    BaseTable,
+			Comment: "People who have placed orders",
+			Columns: []Column{
+				{Name: "id", Type: "integer", NotNull: true},
+				{Name: "Full Name", Type: "character varying(200)", Comment: `Given at "sign up"`},
+			},
+			PrimaryKey: []string{"id"},
+		},
+		{
+			Schema: "public",
+			Name:   "order_lines",
+			Kind:   BaseTable,
+			Columns: []Column{
+				{Name: "order_id", Type: "bigint"},
+				{Name: "n", Type: "int"},
+				{Name: "customer_id", Type: "integer"},
+				{Name: "coupon", Type: "text", Default: "'none'"},
+			},
+			PrimaryKey: []string{"order_id", "n"},
+			ForeignKeys: []ForeignKey{
+				{Columns: []string{"customer_id"}, ReferencedTable: "public.customers"},
+				{Columns: []string{"coupon"}, ReferencedTable: "public.coupons", ReferencedColumns: []string{"code"}},
+			},
+		},
+		{
+			Database: "reports",
+			Schema:   "sales",
+			Name:     "totals",
+			Kind:     View,
+			Columns: []Column{
+				{Name: "customer_id", Type: "integer"},
+				{Name: "total", Type: "numeric(10,2)"},
+			},
+			ForeignKeys: []ForeignKey{
+				{Columns: []string{"customer_id"}, ReferencedTable: "public.customers", ReferencedColumns: []string{"id"}},
+			},
+		},
+	},
+}
+
+func TestDBML(t *testing.T) {
+	want := `Table public.customers {
+  id integer [pk, not null]
+  "Full Name" "character varying(200)" [note: 'Given at "sign up"']
+
+  Note: 'People who have placed orders'
+}
+
+Table public.order_lines {
+  order_id bigint
+  n int
+  customer_id integer
+  coupon text [default: ` + "`'none'`" + `]
+
+  indexes {
+    (order_id, n) [pk]
+  }
+}
+
+Table "reports.sales".totals {
+  customer_id integer
+  total numeric(10,2)
+
+  Note: 'VIEW'
+}
+
+Ref: public.order_lines.customer_id > public.customers.id
+Ref: "reports.sales".totals.customer_id > public.customers.id
+`
+	if got := exportSchema.DBML(); got != want {
+		t.Errorf("got:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestMermaid(t *testing.T) {
+	want := `erDiagram
+    public_customers {
+        integer id PK
+        character_varying_200 Full_Name "Given at 'sign up'"
+    }
+    public_order_lines {
+        bigint order_id PK
+        int n PK
+        integer customer_id FK
+        text coupon FK
+    }
+    reports_sales_totals {
+        integer customer_id FK
+        numeric_10_2 total
+    }
+    public_order_lines }o--|| public_customers : "customer_id"
+    reports_sales_totals }o--|| public_customers : "customer_id"
+`
+	if got := exportSchema.Mermaid(); got != want {
+		t.Errorf("got:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestExportUnknownFormat(t *testing.T) {
+	if _, err := exportSchema.Export("xml"); err == nil {
+		t.Error("expected an error")
+	}
+}
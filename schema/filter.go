@@ -0,0 +1,165 @@
+package schema
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// nameFilter selects names using include and exclude patterns. A name is
+// selected if it matches any include pattern, or there are none, and does
+// not match any exclude pattern.
+type nameFilter struct {
+	include []namePattern
+	exclude []namePattern
+}
+
+func newNameFilter(include, exclude []string) (nameFilter, error) {
+	var f nameFilter
+	var err error
+	if f.include, err = parseNamePatterns(include); err != nil {
+		return nameFilter{}, err
+	}
+	if f.exclude, err = parseNamePatterns(exclude); err != nil {
+		return nameFilter{}, err
+	}
+	return f, nil
+}
+
+// parseNamePatterns parses a list of patterns, ignoring any that are blank
+func parseNamePatterns(patterns []string) ([]namePattern, error) {
+	var out []namePattern
+	for _, pattern := range patterns {
+		if strings.TrimSpace(pattern) == "" {
+			continue
+		}
+		p, err := parseNamePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func (f nameFilter) empty() bool {
+	return len(f.include) == 0 && len(f.exclude) == 0
+}
+
+// selects returns true if the dot-separated name should be loaded
+func (f nameFilter) selects(name string) bool {
+	included := len(f.include) == 0
+	for _, p := range f.include {
+		if p.matches(name) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, p := range f.exclude {
+		if p.matches(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// namePattern matches dot-separated names, such as schema.table or
+// table.column, case-insensitively.
+//
+// Patterns wrapped in slashes, such as /^tmp_\d+$/, are regular expressions
+// matched against the full name. Other patterns are globs, as supported by
+// path.Match, matched against the same number of trailing parts of the name.
+// For example, "tmp_*" matches tables named tmp_1 in any schema, while
+// "staging.*" matches all tables in the staging schema.
+type namePattern struct {
+	glob   string
+	parts  int
+	regexp *regexp.Regexp
+}
+
+func parseNamePattern(pattern string) (namePattern, error) {
+	pattern = strings.TrimSpace(pattern)
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile("(?i)" + pattern[1:len(pattern)-1])
+		if err != nil {
+			return namePattern{}, fmt.Errorf("parsing pattern %q: %w", pattern, err)
+		}
+		return namePattern{regexp: re}, nil
+	}
+
+	glob := strings.ToLower(pattern)
+	if _, err := path.Match(glob, ""); err != nil {
+		return namePattern{}, fmt.Errorf("parsing pattern %q: %w", pattern, err)
+	}
+	return namePattern{
+		glob:  glob,
+		parts: strings.Count(glob, ".") + 1,
+	}, nil
+}
+
+func (p namePattern) matches(name string) bool {
+	if p.regexp != nil {
+		return p.regexp.MatchString(name)
+	}
+	parts := strings.Split(strings.ToLower(name), ".")
+	if len(parts) < p.parts {
+		return false
+	}
+	matched, _ := path.Match(p.glob, strings.Join(parts[len(parts)-p.parts:], "."))
+	return matched
+}
+
+// filterTables returns the tables selected by the table filter, with their
+// columns restricted to those selected by the column filter. Keys using
+// removed columns, or referencing removed tables, are also removed.
+func filterTables(tables []Table, tableFilter, columnFilter nameFilter) []Table {
+	if tableFilter.empty() && columnFilter.empty() {
+		return tables
+	}
+
+	var out []Table
+	for _, table := range tables {
+		if !tableFilter.selects(table.QualifiedName()) {
+			continue
+		}
+
+		removed := make(map[string]bool)
+		var columns []Column
+		for _, column := range table.Columns {
+			if columnFilter.selects(table.QualifiedName() + "." + column.Name) {
+				columns = append(columns, column)
+			} else {
+				removed[column.Name] = true
+			}
+		}
+		table.Columns = columns
+
+		if containsAny(table.PrimaryKey, removed) {
+			table.PrimaryKey = nil
+		}
+		var foreignKeys []ForeignKey
+		for _, foreignKey := range table.ForeignKeys {
+			if containsAny(foreignKey.Columns, removed) || !tableFilter.selects(foreignKey.ReferencedTable) {
+				continue
+			}
+			foreignKeys = append(foreignKeys, foreignKey)
+		}
+		table.ForeignKeys = foreignKeys
+
+		out = append(out, table)
+	}
+	return out
+}
+
+func containsAny(values []string, set map[string]bool) bool {
+	for _, value := range values {
+		if set[value] {
+			return true
+		}
+	}
+	return false
+}
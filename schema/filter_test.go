@@ -0,0 +1,167 @@
+package schema
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNameFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		// selected and rejected are names the filter is expected to select
+		// and reject
+		selected []string
+		rejected []string
+	}{
+		{
+			name:     "no patterns",
+			selected: []string{"orders", "public.orders"},
+		},
+		{
+			name:     "glob matches trailing parts",
+			include:  []string{"ORDER*"},
+			selected: []string{"orders", "public.Orders", "db.sales.order_items"},
+			rejected: []string{"customers", "orders.id"},
+		},
+		{
+			name:     "glob with schema",
+			include:  []string{"staging.*"},
+			selected: []string{"staging.orders", "db.staging.orders"},
+			rejected: []string{"orders", "public.orders"},
+		},
+		{
+			name:     "exclude overrides include",
+			include:  []string{"*"},
+			exclude:  []string{"tmp_*", " "},
+			selected: []string{"orders", "public.customers"},
+			rejected: []string{"tmp_1", "public.tmp_orders"},
+		},
+		{
+			name:     "regular expression matches full name",
+			exclude:  []string{`/^public\.tmp_\d+$/`},
+			selected: []string{"tmp_1", "public.tmp_orders", "staging.public.tmp_1x"},
+			rejected: []string{"public.tmp_1", "PUBLIC.TMP_22"},
+		},
+		{
+			name:     "column patterns",
+			exclude:  []string{"*.password", "/secret/"},
+			selected: []string{"users.name", "public.users.id"},
+			rejected: []string{"users.password", "public.users.Password", "users.secret_key"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := newNameFilter(test.include, test.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range test.selected {
+				if !f.selects(name) {
+					t.Errorf("expected %v to be selected", name)
+				}
+			}
+			for _, name := range test.rejected {
+				if f.selects(name) {
+					t.Errorf("expected %v to be rejected", name)
+				}
+			}
+		})
+	}
+}
+
+func TestNameFilterErrors(t *testing.T) {
+	for _, pattern := range []string{"[a-", "/(/"} {
+		if _, err := newNameFilter([]string{pattern}, nil); err == nil {
+			t.Errorf("expected an error parsing %q", pattern)
+		}
+	}
+}
+
+func TestFilterTables(t *testing.T) {
+	tables := []Table{
+		{
+			Schema:     "public",
+			Name:       "customers",
+			Columns:    []Column{{Name: "id"}, {Name: "name"}, {Name: "password"}},
+			PrimaryKey: []string{"id"},
+		},
+		{
+			Schema:     "public",
+			Name:       "orders",
+			Columns:    []Column{{Name: "id"}, {Name: "customer_id"}, {Name: "coupon_id"}},
+			PrimaryKey: []string{"id"},
+			ForeignKeys: []ForeignKey{
+				{Columns: []string{"customer_id"}, ReferencedTable: "public.customers"},
+				{Columns: []string{"coupon_id"}, ReferencedTable: "public.tmp_coupons"},
+			},
+		},
+		{
+			Schema:     "public",
+			Name:       "tmp_coupons",
+			Columns:    []Column{{Name: "id"}},
+			PrimaryKey: []string{"id"},
+		},
+	}
+	tests := []struct {
+		name          string
+		tableExclude  []string
+		columnExclude []string
+		want          []Table
+	}{
+		{
+			name: "no filters",
+			want: tables,
+		},
+		{
+			name:         "excluded table and references to it",
+			tableExclude: []string{"tmp_*"},
+			want: []Table{
+				tables[0],
+				{
+					Schema:     "public",
+					Name:       "orders",
+					Columns:    []Column{{Name: "id"}, {Name: "customer_id"}, {Name: "coupon_id"}},
+					PrimaryKey: []string{"id"},
+					ForeignKeys: []ForeignKey{
+						{Columns: []string{"customer_id"}, ReferencedTable: "public.customers"},
+					},
+				},
+			},
+		},
+		{
+			name:          "excluded columns and keys using them",
+			tableExclude:  []string{"/coupons/"},
+			columnExclude: []string{"customers.password", "*.customer_id", "customers.id"},
+			want: []Table{
+				{
+					Schema:  "public",
+					Name:    "customers",
+					Columns: []Column{{Name: "name"}},
+				},
+				{
+					Schema:     "public",
+					Name:       "orders",
+					Columns:    []Column{{Name: "id"}, {Name: "coupon_id"}},
+					PrimaryKey: []string{"id"},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tableFilter, err := newNameFilter(nil, test.tableExclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			columnFilter, err := newNameFilter(nil, test.columnExclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := filterTables(tables, tableFilter, columnFilter); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("\ngot  %+v\nwant %+v", got, test.want)
+			}
+		})
+	}
+}
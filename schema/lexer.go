@@ -0,0 +1,512 @@
+package schema
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type ddlTokenKind int
+
+const (
+	ddlWord ddlTokenKind = iota
+	ddlQuotedIdentifier
+	ddlString
+	ddlNumber
+	ddlPunct
+	ddlComment
+)
+
+// ddlToken is a token in a SQL statement
+type ddlToken struct {
+	kind ddlTokenKind
+	// raw is the token as written
+	raw string
+	// value is the content of strings, quoted identifiers and comments
+	value string
+	// line is the line the token starts on, and start and end its offsets
+	line       int
+	start, end int
+}
+
+// is returns true if the token is the given keyword or punctuation
+func (t ddlToken) is(text string) bool {
+	return (t.kind == ddlWord || t.kind == ddlPunct) && strings.EqualFold(t.raw, text)
+}
+
+func (t ddlToken) endLine() int {
+	return t.line + strings.Count(t.raw, "\n")
+}
+
+// identifier returns the name given by a token, without any quotes
+func identifier(t *ddlToken) string {
+	if t == nil {
+		return ""
+	}
+	if t.kind == ddlQuotedIdentifier {
+		return t.value
+	}
+	return t.raw
+}
+
+// dollarQuote matches the opening of a Postgres dollar-quoted string
+var dollarQuote = regexp.MustCompile(`^\$[A-Za-z_]*\$`)
+
+// ddlSyntax describes the quoting supported by a database's SQL
+type ddlSyntax struct {
+	// backslashEscapes are supported in all strings, as by MySQL
+	backslashEscapes bool
+	// escapeStrings support backslash escapes in strings prefixed with E, as
+	// in E'it\'s', as by Postgres and DuckDB
+	escapeStrings bool
+	// bracketIdentifiers are quoted with square brackets, as by SQLite
+	bracketIdentifiers bool
+}
+
+// syntaxFor returns the syntax of SQL for a database type
+func syntaxFor(dbType string) ddlSyntax {
+	return ddlSyntax{
+		backslashEscapes:   dbType == "mysql",
+		escapeStrings:      dbType == "postgres" || dbType == "duckdb",
+		bracketIdentifiers: dbType == "sqlite3",
+	}
+}
+
+// tokenizeDDL splits SQL into tokens, keeping comments, using the quoting of
+// the given syntax
+func tokenizeDDL(src string, syntax ddlSyntax) ([]ddlToken, error) {
+	var tokens []ddlToken
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		start, startLine := i, line
+		t := ddlToken{line: line, start: i}
+		switch {
+		case c == '\n':
+			line++
+			i++
+			continue
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			i++
+			continue
+		case strings.HasPrefix(src[i:], "--"):
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				end = len(src) - i
+			}
+			i += end
+			t.kind = ddlComment
+			t.value = strings.TrimSpace(src[start+2 : i])
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %v: unterminated comment", startLine)
+			}
+			i += end + 4
+			t.kind = ddlComment
+			t.value = strings.TrimSpace(src[start+2 : i-2])
+		case c == '\'' || (syntax.escapeStrings && (c == 'E' || c == 'e') && strings.HasPrefix(src[i+1:], "'")):
+			escapes := syntax.backslashEscapes || c != '\''
+			if c != '\'' {
+				i++
+			}
+			var value strings.Builder
+			i++
+			for {
+				if i >= len(src) {
+					return nil, fmt.Errorf("line %v: unterminated string", startLine)
+				}
+				if escapes && src[i] == '\\' && i+1 < len(src) {
+					value.WriteString(unescapeDDL(src[i+1]))
+					i += 2
+					continue
+				}
+				if src[i] == '\'' {
+					if i+1 < len(src) && src[i+1] == '\'' {
+						value.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				value.WriteByte(src[i])
+				i++
+			}
+			t.kind = ddlString
+			t.value = value.String()
+		case c == '"' || c == '`':
+			var value strings.Builder
+			i++
+			for {
+				if i >= len(src) {
+					return nil, fmt.Errorf("line %v: unterminated identifier", startLine)
+				}
+				if src[i] == c {
+					if i+1 < len(src) && src[i+1] == c {
+						value.WriteByte(c)
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				value.WriteByte(src[i])
+				i++
+			}
+			t.kind = ddlQuotedIdentifier
+			t.value = value.String()
+		case c == '[' && syntax.bracketIdentifiers && !strings.HasPrefix(src[i:], "[]"):
+			end := strings.IndexByte(src[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("line %v: unterminated identifier", startLine)
+			}
+			t.kind = ddlQuotedIdentifier
+			t.value = src[i+1 : i+end]
+			i += end + 1
+		case c == '$' && dollarQuote.MatchString(src[i:]):
+			tag := dollarQuote.FindString(src[i:])
+			end := strings.Index(src[i+len(tag):], tag)
+			if end < 0 {
+				return nil, fmt.Errorf("line %v: unterminated string", startLine)
+			}
+			t.kind = ddlString
+			t.value = src[i+len(tag) : i+len(tag)+end]
+			i += len(tag) + end + len(tag)
+		case isDigit(c) || (c == '.' && i+1 < len(src) && isDigit(src[i+1])):
+			for i < len(src) && (isDigit(src[i]) || src[i] == '.') {
+				i++
+			}
+			if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				i++
+				if i < len(src) && (src[i] == '+' || src[i] == '-') {
+					i++
+				}
+				for i < len(src) && isDigit(src[i]) {
+					i++
+				}
+			}
+			t.kind = ddlNumber
+		case isWordByte(c):
+			for i < len(src) && (isWordByte(src[i]) || isDigit(src[i]) || src[i] == '$') {
+				i++
+			}
+			t.kind = ddlWord
+		case strings.HasPrefix(src[i:], "::"):
+			i += 2
+			t.kind = ddlPunct
+		default:
+			i++
+			t.kind = ddlPunct
+		}
+		t.raw = src[start:i]
+		t.end = i
+		line += strings.Count(t.raw, "\n")
+		tokens = append(tokens, t)
+	}
+	return tokens, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isWordByte returns true for bytes that may start a keyword or unquoted
+// identifier, including any non-ASCII bytes
+func isWordByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func unescapeDDL(c byte) string {
+	switch c {
+	case 'n':
+		return "\n"
+	case 't':
+		return "\t"
+	case 'r':
+		return "\r"
+	case '0':
+		return "\x00"
+	default:
+		return string(c)
+	}
+}
+
+// splitDDLStatements splits tokens into statements separated by semicolons.
+// Each statement starts with any comments on the lines directly before it.
+func splitDDLStatements(tokens []ddlToken) [][]ddlToken {
+	var statements [][]ddlToken
+	var current, comments []ddlToken
+	lastLine := 0
+	for _, t := range tokens {
+		switch {
+		case t.kind == ddlComment && len(current) == 0:
+			// Comments following a semicolon on the same line describe the
+			// previous statement
+			if t.line != lastLine {
+				comments = append(comments, t)
+			}
+		case t.is(";"):
+			if len(current) > 0 {
+				statements = append(statements, current)
+			}
+			current, comments = nil, nil
+			lastLine = t.line
+		default:
+			if len(current) == 0 && t.kind != ddlComment {
+				current = adjacentComments(comments, t.line)
+				comments = nil
+			}
+			current = append(current, t)
+		}
+	}
+	if len(current) > 0 {
+		statements = append(statements, current)
+	}
+	return statements
+}
+
+// adjacentComments returns the comments on the lines directly before line,
+// without any blank lines between them
+func adjacentComments(comments []ddlToken, line int) []ddlToken {
+	start := len(comments)
+	for start > 0 && comments[start-1].endLine() == line-1 {
+		start--
+		line = comments[start].line
+	}
+	return append([]ddlToken(nil), comments[start:]...)
+}
+
+// joinTokens writes tokens as SQL, with spaces between words but not around
+// punctuation, such as "numeric(10, 2)" or "now()"
+func joinTokens(tokens []ddlToken) string {
+	var out strings.Builder
+	for i, t := range tokens {
+		if i > 0 && !spaceBefore(tokens, i) {
+			out.WriteString(t.raw)
+			continue
+		}
+		if i > 0 {
+			out.WriteString(" ")
+		}
+		out.WriteString(t.raw)
+	}
+	return out.String()
+}
+
+func spaceBefore(tokens []ddlToken, i int) bool {
+	switch t := tokens[i]; {
+	case t.is("(") || t.is(")") || t.is(",") || t.is("[") || t.is("]") || t.is(".") || t.is("::"):
+		return false
+	}
+	switch previous := tokens[i-1]; {
+	case previous.is("(") || previous.is("[") || previous.is(".") || previous.is("::"):
+		return false
+	case previous.is("-"):
+		// A minus sign at the start of an expression is a negative number
+		return i > 1 && tokens[i-2].kind != ddlPunct
+	}
+	return true
+}
+
+// ddlParser reads the tokens of a statement
+type ddlParser struct {
+	src    string
+	tokens []ddlToken
+	pos    int
+}
+
+// peek returns the next token that is not a comment, without reading it
+func (p *ddlParser) peek() *ddlToken {
+	for i := p.pos; i < len(p.tokens); i++ {
+		if p.tokens[i].kind != ddlComment {
+			return &p.tokens[i]
+		}
+	}
+	return nil
+}
+
+// next reads the next token that is not a comment
+func (p *ddlParser) next() *ddlToken {
+	for p.pos < len(p.tokens) {
+		t := &p.tokens[p.pos]
+		p.pos++
+		if t.kind != ddlComment {
+			return t
+		}
+	}
+	return nil
+}
+
+// match returns the position after the given sequence of keywords or
+// punctuation, if the next tokens match it
+func (p *ddlParser) match(words []string) (int, bool) {
+	i := p.pos
+	for _, word := range words {
+		for i < len(p.tokens) && p.tokens[i].kind == ddlComment {
+			i++
+		}
+		if i >= len(p.tokens) || !p.tokens[i].is(word) {
+			return 0, false
+		}
+		i++
+	}
+	return i, true
+}
+
+// at returns true if the next tokens are the given sequence
+func (p *ddlParser) at(words ...string) bool {
+	_, ok := p.match(words)
+	return ok
+}
+
+// atAny returns true if the next token is any of the given words
+func (p *ddlParser) atAny(words ...string) bool {
+	for _, word := range words {
+		if p.at(word) {
+			return true
+		}
+	}
+	return false
+}
+
+// accept reads the given sequence, if the next tokens match it
+func (p *ddlParser) accept(words ...string) bool {
+	end, ok := p.match(words)
+	if ok {
+		p.pos = end
+	}
+	return ok
+}
+
+// acceptAny reads the next token if it is any of the given words
+func (p *ddlParser) acceptAny(words ...string) bool {
+	for _, word := range words {
+		if p.accept(word) {
+			return true
+		}
+	}
+	return false
+}
+
+// comments reads any comments at the current position
+func (p *ddlParser) comments() []ddlToken {
+	var comments []ddlToken
+	for p.pos < len(p.tokens) && p.tokens[p.pos].kind == ddlComment {
+		comments = append(comments, p.tokens[p.pos])
+		p.pos++
+	}
+	return comments
+}
+
+// leadingComment reads the comments at the current position as one comment
+func (p *ddlParser) leadingComment() string {
+	var comment string
+	for _, c := range p.comments() {
+		comment = joinComments(comment, c.value)
+	}
+	return comment
+}
+
+// line returns the line the statement starts on
+func (p *ddlParser) line() int {
+	for _, t := range p.tokens {
+		if t.kind != ddlComment {
+			return t.line
+		}
+	}
+	return 0
+}
+
+// group reads tokens up to the next comma or closing parenthesis outside of
+// any parentheses, returning comments separately
+func (p *ddlParser) group() (tokens, comments []ddlToken) {
+	depth := 0
+	for ; p.pos < len(p.tokens); p.pos++ {
+		t := p.tokens[p.pos]
+		switch {
+		case t.kind == ddlComment:
+			comments = append(comments, t)
+			continue
+		case depth == 0 && (t.is(",") || t.is(")")):
+			return tokens, comments
+		case t.is("("):
+			depth++
+		case t.is(")"):
+			depth--
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, comments
+}
+
+// until reads tokens until stop returns true outside of any parentheses
+func (p *ddlParser) until(stop func() bool) []ddlToken {
+	var tokens []ddlToken
+	depth := 0
+	for p.peek() != nil && (depth > 0 || !stop()) {
+		t := p.next()
+		switch {
+		case t.is("("):
+			depth++
+		case t.is(")"):
+			depth--
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens
+}
+
+// skip reads the next token, or a whole parenthesized group
+func (p *ddlParser) skip() {
+	t := p.next()
+	if t == nil || !t.is("(") {
+		return
+	}
+	for depth := 1; depth > 0; {
+		t := p.next()
+		switch {
+		case t == nil:
+			return
+		case t.is("("):
+			depth++
+		case t.is(")"):
+			depth--
+		}
+	}
+}
+
+// name reads a dot-separated name, such as schema.table
+func (p *ddlParser) name() ([]string, error) {
+	var parts []string
+	for {
+		t := p.next()
+		if t == nil || (t.kind != ddlWord && t.kind != ddlQuotedIdentifier) {
+			return nil, fmt.Errorf("expected a name")
+		}
+		parts = append(parts, identifier(t))
+		if !p.accept(".") {
+			return parts, nil
+		}
+	}
+}
+
+// identifierList reads a parenthesized list of names, such as the columns of
+// a key, using the first token of each item
+func (p *ddlParser) identifierList() []string {
+	if !p.accept("(") {
+		return nil
+	}
+	var names []string
+	for {
+		tokens, _ := p.group()
+		if len(tokens) > 0 {
+			names = append(names, identifier(&tokens[0]))
+		}
+		if !p.accept(",") {
+			break
+		}
+	}
+	p.accept(")")
+	return names
+}
@@ -0,0 +1,208 @@
+package schema
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type mysqlLoader struct{}
+
+func (m *mysqlLoader) loadTables(db *sql.DB) ([]Table, error) {
+	tables, err := m.tableList(db)
+	if err != nil {
+		return nil, err
+	}
+	index := indexTables(tables)
+
+	if err := m.loadColumns(db, tables, index); err != nil {
+		return nil, err
+	}
+	if err := m.loadKeys(db, tables, index); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+// tableList returns the tables and views in the database selected by the
+// connection, with their approximate row counts, sizes and update times.
+func (m *mysqlLoader) tableList(db *sql.DB) ([]Table, error) {
+	var tables []Table
+	rows, err := db.Query(`
+		SELECT
+			t.table_name,
+			t.table_type = 'VIEW',
+			t.table_comment,
+			COALESCE(v.view_definition, ''),
+			t.table_rows,
+			t.data_length + t.index_length,
+			UNIX_TIMESTAMP(t.update_time)
+		FROM information_schema.tables t
+		LEFT JOIN information_schema.views v ON v.table_schema = t.table_schema AND v.table_name = t.table_name
+		WHERE t.table_schema = DATABASE() AND t.table_type IN ('BASE TABLE', 'VIEW')
+		ORDER BY t.table_name`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("listing tables: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table Table
+		var isView bool
+		var rowCount, sizeBytes, updated sql.NullInt64
+		err := rows.Scan(&table.Name, &isView, &table.Comment, &table.Definition, &rowCount, &sizeBytes, &updated)
+		if err != nil {
+			return nil, fmt.Errorf("scanning tables: %w", err)
+		}
+		var lastModified sql.NullTime
+		if updated.Valid {
+			// Converted from a timestamp so parseTime is not needed in the DSN
+			lastModified = sql.NullTime{Time: time.Unix(updated.Int64, 0), Valid: true}
+		}
+		table.Stats = newTableStats(rowCount, sizeBytes, lastModified)
+		table.Kind = BaseTable
+		if isView {
+			table.Kind = View
+			// Views are given the comment "VIEW" when no comment is set
+			if table.Comment == "VIEW" {
+				table.Comment = ""
+			}
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+// loadColumns loads the columns for all tables
+func (m *mysqlLoader) loadColumns(db *sql.DB, tables []Table, index tableIndex) error {
+	rows, err := db.Query(`
+		SELECT table_name, column_name, column_type, is_nullable = 'NO', column_default, extra, column_comment
+		FROM information_schema.columns
+		WHERE table_schema = DATABASE()
+		ORDER BY table_name, ordinal_position`,
+	)
+	if err != nil {
+		return fmt.Errorf("describing tables: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tableName, extra string
+		var column Column
+		var columnDefault sql.NullString
+		err := rows.Scan(&tableName, &column.Name, &column.Type, &column.NotNull, &columnDefault, &extra, &column.Comment)
+		if err != nil {
+			return fmt.Errorf("scanning columns: %w", err)
+		}
+		if columnDefault.Valid {
+			column.Default = mysqlDefault(columnDefault.String, column.Type, extra)
+		}
+		if table := index.find(tables, Table{Name: tableName}); table != nil {
+			table.Columns = append(table.Columns, column)
+		}
+	}
+	return rows.Err()
+}
+
+// mysqlDefault returns a column default as SQL. MySQL lists string defaults
+// without quotes, so they are quoted unless the column is numeric, or the
+// default is NULL, an expression, or already quoted, as by MariaDB.
+// Expressions are marked as DEFAULT_GENERATED in the extra column, except
+// for CURRENT_TIMESTAMP before MySQL 8 and bit values such as b'1'.
+func mysqlDefault(value, columnType, extra string) string {
+	upper := strings.ToUpper(value)
+	switch {
+	case isNumericType(columnType),
+		upper == "NULL",
+		strings.Contains(strings.ToUpper(extra), "DEFAULT_GENERATED"),
+		isTemporalType(columnType) && strings.HasPrefix(upper, "CURRENT_TIMESTAMP"),
+		strings.HasPrefix(upper, "B'"),
+		strings.HasPrefix(value, "'"):
+		return value
+	}
+	return Value{Text: value}.Literal()
+}
+
+// loadKeys loads the primary key columns and foreign keys for all tables
+func (m *mysqlLoader) loadKeys(db *sql.DB, tables []Table, index tableIndex) error {
+	rows, err := db.Query(`
+		SELECT k.table_name, k.constraint_name, k.column_name, COALESCE(rc.referenced_table_name, ''), COALESCE(k.referenced_column_name, '')
+		FROM information_schema.key_column_usage k
+		LEFT JOIN information_schema.referential_constraints rc
+			ON rc.constraint_schema = k.constraint_schema AND rc.table_name = k.table_name AND rc.constraint_name = k.constraint_name
+		WHERE k.table_schema = DATABASE() AND (k.constraint_name = 'PRIMARY' OR rc.constraint_name IS NOT NULL)
+		ORDER BY k.table_name, k.constraint_name, k.ordinal_position`,
+	)
+	if err != nil {
+		return fmt.Errorf("loading keys: %w", err)
+	}
+	defer rows.Close()
+
+	var lastConstraint string
+	for rows.Next() {
+		var tableName, constraint, column, referencedTable, referencedColumn string
+		err := rows.Scan(&tableName, &constraint, &column, &referencedTable, &referencedColumn)
+		if err != nil {
+			return fmt.Errorf("scanning keys: %w", err)
+		}
+		table := index.find(tables, Table{Name: tableName})
+		if table == nil {
+			continue
+		}
+		if constraint == "PRIMARY" {
+			table.PrimaryKey = append(table.PrimaryKey, column)
+			continue
+		}
+		if key := tableName + "." + constraint; key != lastConstraint {
+			table.ForeignKeys = append(table.ForeignKeys, ForeignKey{ReferencedTable: referencedTable})
+			lastConstraint = key
+		}
+		foreignKey := &table.ForeignKeys[len(table.ForeignKeys)-1]
+		foreignKey.Columns = append(foreignKey.Columns, column)
+		foreignKey.ReferencedColumns = append(foreignKey.ReferencedColumns, referencedColumn)
+	}
+	return rows.Err()
+}
+
+// sampleQueries selects the first rows of a table, as MySQL does not
+// support sampling without scanning the whole table
+func (m *mysqlLoader) sampleQueries(table Table, rows int) []string {
+	return []string{limitQuery(m.quotedName(table), rows)}
+}
+
+func (m *mysqlLoader) quotedName(table Table) string {
+	return m.quote(table.Name)
+}
+
+// quote quotes an identifier using backticks, as double quotes delimit
+// strings unless ANSI_QUOTES is enabled
+func (m *mysqlLoader) quote(identifier string) string {
+	return "`" + strings.ReplaceAll(identifier, "`", "``") + "`"
+}
+
+// fingerprint combines a checksum of all column definitions with the number
+// of tables and key columns in the database
+func (m *mysqlLoader) fingerprint(db *sql.DB) (string, error) {
+	var tableCount, columnCount, keyCount int64
+	var checksum, viewChecksum string
+	// The column type of enum columns includes their values, so changes to
+	// enums are covered by the columns
+	err := db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE()),
+			COUNT(*),
+			COALESCE(SUM(CRC32(CONCAT_WS(':', table_name, column_name, column_type, is_nullable, column_default, column_comment))), 0),
+			(SELECT COUNT(*) FROM information_schema.key_column_usage WHERE table_schema = DATABASE()),
+			(
+				SELECT COALESCE(SUM(CRC32(CONCAT_WS(':', table_name, view_definition))), 0)
+				FROM information_schema.views WHERE table_schema = DATABASE()
+			)
+		FROM information_schema.columns
+		WHERE table_schema = DATABASE()`,
+	).Scan(&tableCount, &columnCount, &checksum, &keyCount, &viewChecksum)
+	if err != nil {
+		return "", fmt.Errorf("fingerprinting schema: %w", err)
+	}
+	return fmt.Sprintf("%v:%v:%v:%v:%v", tableCount, columnCount, checksum, keyCount, viewChecksum), nil
+}
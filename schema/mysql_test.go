@@ -0,0 +1,30 @@
+package schema
+
+import "testing"
+
+func TestMySQLDefault(t *testing.T) {
+	tests := []struct {
+		value      string
+		columnType string
+		extra      string
+		want       string
+	}{
+		{value: "active", columnType: "varchar(20)", want: "'active'"},
+		{value: "it's", columnType: "text", want: "'it''s'"},
+		{value: "0", columnType: "char(1)", want: "'0'"},
+		{value: "0", columnType: "int unsigned", want: "0"},
+		{value: "9.99", columnType: "decimal(10,2)", want: "9.99"},
+		{value: "NULL", columnType: "varchar(20)", want: "NULL"},
+		{value: "CURRENT_TIMESTAMP", columnType: "timestamp", extra: "DEFAULT_GENERATED on update CURRENT_TIMESTAMP", want: "CURRENT_TIMESTAMP"},
+		{value: "CURRENT_TIMESTAMP(3)", columnType: "datetime(3)", want: "CURRENT_TIMESTAMP(3)"},
+		{value: "uuid()", columnType: "varchar(36)", extra: "DEFAULT_GENERATED", want: "uuid()"},
+		{value: "b'1'", columnType: "bit(1)", want: "b'1'"},
+		{value: "'active'", columnType: "varchar(20)", want: "'active'"},
+		{value: "", columnType: "varchar(20)", want: "''"},
+	}
+	for _, test := range tests {
+		if got := mysqlDefault(test.value, test.columnType, test.extra); got != test.want {
+			t.Errorf("%q for %v: got %v, want %v", test.value, test.columnType, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package schema
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Overlay adds business context to a schema, such as descriptions of tables
+// and columns, the other names they are known by, and definitions of the
+// terms used in questions. It is read from YAML such as:
+//
+//	tables:
+//	  orders:
+//	    description: Orders placed through the web store
+//	    synonyms: [purchases]
+//	    columns:
+//	      total:
+//	        description: Order total in USD, including tax
+//	glossary:
+//	  - term: active customer
+//	    definition: A customer who has placed an order in the last 90 days
+//	    references: [orders.created_at, customers]
+//
+// Tables are named as for WithTableFilter, without any wildcards, so "orders"
+// matches the orders table in any schema as long as there is only one.
+type Overlay struct {
+	Tables   map[string]TableOverlay `yaml:"tables"`
+	Glossary []GlossaryTerm          `yaml:"glossary"`
+}
+
+// TableOverlay describes a table and its columns
+type TableOverlay struct {
+	Description string                   `yaml:"description"`
+	Synonyms    []string                 `yaml:"synonyms"`
+	Columns     map[string]ColumnOverlay `yaml:"columns"`
+}
+
+// ColumnOverlay describes a column
+type ColumnOverlay struct {
+	Description string   `yaml:"description"`
+	Synonyms    []string `yaml:"synonyms"`
+}
+
+// GlossaryTerm defines a business term, such as a metric
+type GlossaryTerm struct {
+	Term       string   `yaml:"term" json:"term"`
+	Synonyms   []string `yaml:"synonyms" json:"synonyms,omitempty"`
+	Definition string   `yaml:"definition" json:"definition"`
+	// SQL is an optional expression or condition implementing the term
+	SQL string `yaml:"sql" json:"sql,omitempty"`
+	// References lists the tables and columns, as table.column, used by the
+	// term
+	References []string `yaml:"references" json:"references,omitempty"`
+}
+
+// String describes the term for use in a SQL comment
+func (g GlossaryTerm) String() string {
+	out := g.Term
+	if len(g.Synonyms) > 0 {
+		out += fmt.Sprintf(" (also called %v)", strings.Join(g.Synonyms, ", "))
+	}
+	out += ": " + singleLine(g.Definition)
+	if g.SQL != "" {
+		out += "; as SQL: " + singleLine(g.SQL)
+	}
+	if len(g.References) > 0 {
+		out += "; uses " + strings.Join(g.References, ", ")
+	}
+	return out
+}
+
+// WithOverlay merges the overlay in the YAML file at path into the schema.
+// The file is read each time the schema is loaded, so changes are picked up
+// when the schema is reloaded.
+func WithOverlay(path string) Option {
+	return func(o *options) {
+		o.overlayPath = path
+	}
+}
+
+// LoadOverlay reads an overlay from a YAML file. Unknown fields are treated
+// as errors, to catch mistakes in the file.
+func LoadOverlay(path string) (Overlay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Overlay{}, fmt.Errorf("reading overlay: %w", err)
+	}
+	defer f.Close()
+
+	var overlay Overlay
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&overlay); err != nil {
+		return Overlay{}, fmt.Errorf("parsing overlay %v: %w", path, err)
+	}
+	return overlay, nil
+}
+
+// applyOverlay merges the configured overlay file, if any, into the schema
+func (o options) applyOverlay(s Schema) (Schema, error) {
+	if o.overlayPath == "" {
+		return s, nil
+	}
+	overlay, err := LoadOverlay(o.overlayPath)
+	if err != nil {
+		return Schema{}, err
+	}
+	return overlay.Apply(s)
+}
+
+// Apply returns the schema with the overlay merged into it. Descriptions
+// are added to any existing comments. An error listing every problem is
+// returned if the overlay refers to tables or columns not in the schema, so
+// it can be corrected when the database changes.
+func (ov Overlay) Apply(s Schema) (Schema, error) {
+	// Copy the tables, so the given schema is not modified
+	tables := make([]Table, len(s.Tables))
+	copy(tables, s.Tables)
+	for i := range tables {
+		tables[i].Columns = append([]Column(nil), tables[i].Columns...)
+	}
+	s.Tables = tables
+
+	var problems []string
+	for _, name := range sortedKeys(ov.Tables) {
+		tableOverlay := ov.Tables[name]
+		table, err := s.findTable(name)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		table.Comment = joinDescriptions(table.Comment, tableOverlay.Description)
+		table.Synonyms = append(table.Synonyms, tableOverlay.Synonyms...)
+
+		for _, columnName := range sortedKeys(tableOverlay.Columns) {
+			columnOverlay := tableOverlay.Columns[columnName]
+			column := table.findColumn(columnName)
+			if column == nil {
+				problems = append(problems, fmt.Sprintf("column %v not found in table %v", columnName, table.QualifiedName()))
+				continue
+			}
+			column.Comment = joinDescriptions(column.Comment, columnOverlay.Description)
+			column.Synonyms = append(column.Synonyms, columnOverlay.Synonyms...)
+		}
+	}
+
+	s.Glossary = nil
+	for i, term := range ov.Glossary {
+		if strings.TrimSpace(term.Term) == "" {
+			problems = append(problems, fmt.Sprintf("glossary entry %v has no term", i+1))
+			continue
+		}
+		if strings.TrimSpace(term.Definition) == "" {
+			problems = append(problems, fmt.Sprintf("glossary term %q has no definition", term.Term))
+		}
+
+		var references []string
+		for _, reference := range term.References {
+			resolved, err := s.resolveReference(reference)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("glossary term %q: %v", term.Term, err))
+				continue
+			}
+			references = append(references, resolved)
+		}
+		term.References = references
+		s.Glossary = append(s.Glossary, term)
+	}
+
+	if len(problems) > 0 {
+		return Schema{}, fmt.Errorf("invalid overlay:\n  %v", strings.Join(problems, "\n  "))
+	}
+	return s, nil
+}
+
+// findTable returns the only table whose qualified name ends with the given
+// name, compared case-insensitively
+func (s Schema) findTable(name string) (*Table, error) {
+	var found *Table
+	name = strings.ToLower(name)
+	for i := range s.Tables {
+		qualified := strings.ToLower(s.Tables[i].QualifiedName())
+		if qualified != name && !strings.HasSuffix(qualified, "."+name) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("table %v is ambiguous, matching %v and %v", name, found.QualifiedName(), s.Tables[i].QualifiedName())
+		}
+		found = &s.Tables[i]
+	}
+	if found == nil {
+		return nil, fmt.Errorf("table %v not found", name)
+	}
+	return found, nil
+}
+
+// resolveReference returns the qualified name of the table, or table and
+// column, referred to by a glossary term
+func (s Schema) resolveReference(reference string) (string, error) {
+	if table, err := s.findTable(reference); err == nil {
+		return table.QualifiedName(), nil
+	}
+	tableName, columnName, ok := cutLast(reference, ".")
+	if !ok {
+		return "", fmt.Errorf("table %v not found", reference)
+	}
+	table, err := s.findTable(tableName)
+	if err != nil {
+		return "", err
+	}
+	column := table.findColumn(columnName)
+	if column == nil {
+		return "", fmt.Errorf("column %v not found in table %v", columnName, table.QualifiedName())
+	}
+	return table.QualifiedName() + "." + column.Name, nil
+}
+
+// findColumn returns the column with the given name, compared
+// case-insensitively
+func (t *Table) findColumn(name string) *Column {
+	for i := range t.Columns {
+		if strings.EqualFold(t.Columns[i].Name, name) {
+			return &t.Columns[i]
+		}
+	}
+	return nil
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+func joinDescriptions(existing, description string) string {
+	switch {
+	case description == "":
+		return existing
+	case existing == "":
+		return description
+	default:
+		return strings.TrimRight(existing, ". ") + ". " + description
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,106 @@
+package schema
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func overlaySchema() Schema {
+	return Schema{
+		Tables: []Table{
+			{
+				Schema:  "public",
+				Name:    "orders",
+				Comment: "Orders.",
+				Columns: []Column{{Name: "id"}, {Name: "Total", Comment: "In cents"}},
+			},
+			{
+				Schema:  "public",
+				Name:    "customers",
+				Columns: []Column{{Name: "id"}},
+			},
+			{
+				Schema:  "staging",
+				Name:    "customers",
+				Columns: []Column{{Name: "id"}},
+			},
+		},
+	}
+}
+
+func TestOverlayApply(t *testing.T) {
+	overlay := Overlay{
+		Tables: map[string]TableOverlay{
+			"ORDERS": {
+				Description: "Placed through the web store",
+				Synonyms:    []string{"purchases"},
+				Columns: map[string]ColumnOverlay{
+					"total": {Description: "Including tax", Synonyms: []string{"amount"}},
+				},
+			},
+			"staging.customers": {Synonyms: []string{"leads"}},
+		},
+		Glossary: []GlossaryTerm{
+			{Term: "big order", Definition: "An order over $100", References: []string{"orders.total", "public.customers"}},
+		},
+	}
+	s := overlaySchema()
+	got, err := overlay.Apply(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := overlaySchema()
+	want.Tables[0].Comment = "Orders. Placed through the web store"
+	want.Tables[0].Synonyms = []string{"purchases"}
+	want.Tables[0].Columns[1] = Column{Name: "Total", Comment: "In cents. Including tax", Synonyms: []string{"amount"}}
+	want.Tables[2].Synonyms = []string{"leads"}
+	want.Glossary = []GlossaryTerm{
+		{Term: "big order", Definition: "An order over $100", References: []string{"public.orders.Total", "public.customers"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\ngot  %+v\nwant %+v", got, want)
+	}
+	if !reflect.DeepEqual(s, overlaySchema()) {
+		t.Errorf("expected the schema to be unchanged, got %+v", s)
+	}
+}
+
+func TestOverlayApplyErrors(t *testing.T) {
+	overlay := Overlay{
+		Tables: map[string]TableOverlay{
+			"missing":   {Description: "Not in the schema"},
+			"customers": {Description: "Ambiguous"},
+			"orders": {
+				Columns: map[string]ColumnOverlay{"missing": {Description: "Not in the table"}},
+			},
+		},
+		Glossary: []GlossaryTerm{
+			{Definition: "No term"},
+			{Term: "undefined"},
+			{Term: "bad references", Definition: "Refers to missing tables", References: []string{"orders.missing", "missing"}},
+		},
+	}
+	s := overlaySchema()
+	_, err := overlay.Apply(s)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, problem := range []string{
+		"table missing not found",
+		"table customers is ambiguous, matching public.customers and staging.customers",
+		"column missing not found in table public.orders",
+		"glossary entry 1 has no term",
+		`glossary term "undefined" has no definition`,
+		`glossary term "bad references": column missing not found in table public.orders`,
+		`glossary term "bad references": table missing not found`,
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %q in the error, got %v", problem, err)
+		}
+	}
+	if !reflect.DeepEqual(s, overlaySchema()) {
+		t.Errorf("expected the schema to be unchanged, got %+v", s)
+	}
+}
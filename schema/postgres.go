@@ -1,98 +1,415 @@
 package schema
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"time"
+	"sort"
+
+	"github.com/lib/pq"
 )
 
-type postgresLoader struct{}
+type postgresLoader struct {
+	// schemas restricts the tables loaded to those in the named schemas.
+	// If empty, tables are loaded from all non-system schemas.
+	schemas []string
+}
+
+// postgresRelationFilter restricts catalog queries to the tables, views and
+// materialized views in the schemas to be loaded. It expects pg_class to be
+// aliased as c, pg_namespace as n and the list of schemas to be passed as $1.
+const postgresRelationFilter = `
+	c.relkind IN ('r', 'p', 'v', 'm')
+	AND NOT c.relispartition
+	AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+	AND n.nspname NOT LIKE 'pg_toast%'
+	AND ($1::text[] IS NULL OR n.nspname::text = ANY($1::text[]))`
 
-func (p *postgresLoader) tableList(db *sql.DB) ([]string, error) {
-	var tables []string
-	rows, err := db.Query("SELECT tablename FROM pg_catalog.pg_tables WHERE schemaname != 'pg_catalog' AND schemaname != 'information_schema';")
+func (p *postgresLoader) loadTables(db *sql.DB) ([]Table, error) {
+	tables, err := p.tableList(db)
+	if err != nil {
+		return nil, err
+	}
+	index := indexTables(tables)
+
+	if err := p.loadColumns(db, tables, index); err != nil {
+		return nil, err
+	}
+	if err := p.loadKeys(db, tables, index); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+// tableList returns the tables in the database, with their approximate row
+// counts and sizes. Row counts are estimates from the last ANALYZE, and
+// partitioned tables are summarized from their partitions.
+func (p *postgresLoader) tableList(db *sql.DB) ([]Table, error) {
+	var tables []Table
+	rows, err := db.Query(`
+		SELECT
+			n.nspname,
+			c.relname,
+			c.relkind,
+			COALESCE(obj_description(c.oid, 'pg_class'), ''),
+			CASE WHEN c.relkind IN ('v', 'm') THEN pg_get_viewdef(c.oid) ELSE '' END,
+			CASE
+				WHEN c.relkind IN ('r', 'm') AND c.reltuples >= 0 THEN c.reltuples::bigint
+				WHEN c.relkind = 'p' THEN (
+					SELECT SUM(GREATEST(pc.reltuples, 0))::bigint FROM pg_catalog.pg_inherits i
+					JOIN pg_catalog.pg_class pc ON pc.oid = i.inhrelid
+					WHERE i.inhparent = c.oid
+				)
+			END,
+			CASE
+				WHEN c.relkind IN ('r', 'm') THEN pg_total_relation_size(c.oid)
+				WHEN c.relkind = 'p' THEN (
+					SELECT SUM(pg_total_relation_size(i.inhrelid))::bigint FROM pg_catalog.pg_inherits i
+					WHERE i.inhparent = c.oid
+				)
+			END
+		FROM pg_catalog.pg_class c
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE `+postgresRelationFilter+`
+		ORDER BY n.nspname, c.relname`,
+		pq.Array(p.schemas),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("listing tables: %w", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var table string
-		rows.Scan(&table)
+		var table Table
+		var kind string
+		var rowCount, sizeBytes sql.NullInt64
+		err := rows.Scan(&table.Schema, &table.Name, &kind, &table.Comment, &table.Definition, &rowCount, &sizeBytes)
+		if err != nil {
+			return nil, fmt.Errorf("scanning tables: %w", err)
+		}
+		table.Stats = newTableStats(rowCount, sizeBytes, sql.NullTime{})
+		switch kind {
+		case "v":
+			table.Kind = View
+		case "m":
+			table.Kind = MaterializedView
+		default:
+			table.Kind = BaseTable
+		}
 		tables = append(tables, table)
 	}
-	return tables, nil
+	return tables, rows.Err()
 }
 
-// describeTable returns a list of Column descriptions for a given table
-func (p *postgresLoader) describeTable(table string, db *sql.DB) (Table, error) {
-	var columns []Column
-	rows, err := db.Query("SELECT column_name, data_type FROM information_schema.columns WHERE table_name = $1", table)
+// loadColumns loads the columns for all tables. Columns are loaded from
+// pg_attribute rather than information_schema, which omits materialized views
+// and reports user-defined types without their details. The labels of enum
+// types, element types of arrays and fields of composite types are loaded
+// along with each column.
+func (p *postgresLoader) loadColumns(db *sql.DB, tables []Table, index tableIndex) error {
+	rows, err := db.Query(`
+		SELECT
+			n.nspname,
+			c.relname,
+			a.attname,
+			format_type(a.atttypid, a.atttypmod),
+			a.attnotnull,
+			COALESCE(pg_get_expr(d.adbin, d.adrelid), ''),
+			COALESCE(col_description(a.attrelid, a.attnum), ''),
+			ARRAY(
+				SELECT e.enumlabel::text FROM pg_catalog.pg_enum e
+				WHERE e.enumtypid IN (t.oid, t.typelem)
+				ORDER BY e.enumsortorder
+			),
+			CASE WHEN t.typcategory = 'A' THEN format_type(t.typelem, NULL) ELSE '' END,
+			ARRAY(
+				SELECT f.attname || ' ' || format_type(f.atttypid, f.atttypmod) FROM pg_catalog.pg_attribute f
+				WHERE t.typtype = 'c' AND f.attrelid = t.typrelid AND f.attnum > 0 AND NOT f.attisdropped
+				ORDER BY f.attnum
+			)
+		FROM pg_catalog.pg_attribute a
+		JOIN pg_catalog.pg_class c ON c.oid = a.attrelid
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_catalog.pg_type t ON t.oid = a.atttypid
+		LEFT JOIN pg_catalog.pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE a.attnum > 0 AND NOT a.attisdropped AND `+postgresRelationFilter+`
+		ORDER BY n.nspname, c.relname, a.attnum`,
+		pq.Array(p.schemas),
+	)
 	if err != nil {
-		return Table{}, fmt.Errorf("describing tables: %w", err)
+		return fmt.Errorf("describing tables: %w", err)
 	}
 	defer rows.Close()
 	for rows.Next() {
+		var schemaName, tableName string
 		var column Column
-		rows.Scan(&column.Name, &column.Type)
-		columns = append(columns, column)
+		err := rows.Scan(
+			&schemaName, &tableName, &column.Name, &column.Type, &column.NotNull, &column.Default, &column.Comment,
+			pq.Array(&column.EnumValues), &column.ElementType, pq.Array(&column.Fields),
+		)
+		if err != nil {
+			return fmt.Errorf("scanning columns: %w", err)
+		}
+		if table := index.find(tables, Table{Schema: schemaName, Name: tableName}); table != nil {
+			table.Columns = append(table.Columns, column)
+		}
 	}
+	return rows.Err()
+}
 
-	exampleRow, err := db.Query(fmt.Sprintf("SELECT * FROM %v LIMIT 1;", table))
+// loadKeys loads the primary key columns and foreign keys for all tables
+func (p *postgresLoader) loadKeys(db *sql.DB, tables []Table, index tableIndex) error {
+	rows, err := db.Query(`
+		SELECT
+			n.nspname,
+			c.relname,
+			con.contype,
+			ARRAY(
+				SELECT a.attname FROM unnest(con.conkey) WITH ORDINALITY AS k(attnum, i)
+				JOIN pg_catalog.pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = k.attnum
+				ORDER BY k.i
+			),
+			COALESCE((
+				SELECT rn.nspname || '.' || rc.relname FROM pg_catalog.pg_class rc
+				JOIN pg_catalog.pg_namespace rn ON rn.oid = rc.relnamespace
+				WHERE rc.oid = con.confrelid
+			), ''),
+			ARRAY(
+				SELECT a.attname FROM unnest(con.confkey) WITH ORDINALITY AS k(attnum, i)
+				JOIN pg_catalog.pg_attribute a ON a.attrelid = con.confrelid AND a.attnum = k.attnum
+				ORDER BY k.i
+			)
+		FROM pg_catalog.pg_constraint con
+		JOIN pg_catalog.pg_class c ON c.oid = con.conrelid
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE con.contype IN ('p', 'f') AND `+postgresRelationFilter+`
+		ORDER BY n.nspname, c.relname, con.conname`,
+		pq.Array(p.schemas),
+	)
 	if err != nil {
-		return Table{}, fmt.Errorf("loading example row: %w", err)
-	}
-	defer exampleRow.Close()
-	var values []interface{}
-	// Read exactly one row, assuming there are any
-	for exampleRow.Next() && len(values) == 0 {
-		for i := 0; i < len(columns); i++ {
-			var value interface{}
-			values = append(values, &value)
-		}
-		err = exampleRow.Scan(values...)
+		return fmt.Errorf("loading keys: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var schemaName, tableName, constraintType, referencedTable string
+		var columns, referencedColumns []string
+		err := rows.Scan(&schemaName, &tableName, &constraintType, pq.Array(&columns), &referencedTable, pq.Array(&referencedColumns))
 		if err != nil {
-			return Table{}, fmt.Errorf("scanning example row: %w", err)
+			return fmt.Errorf("scanning keys: %w", err)
+		}
+		table := index.find(tables, Table{Schema: schemaName, Name: tableName})
+		if table == nil {
+			continue
+		}
+		if constraintType == "p" {
+			table.PrimaryKey = columns
+			continue
 		}
+		table.ForeignKeys = append(table.ForeignKeys, ForeignKey{
+			Columns:           columns,
+			ReferencedTable:   referencedTable,
+			ReferencedColumns: referencedColumns,
+		})
+	}
+	return rows.Err()
+}
+
+// sampleQueries samples rows from 1% of the pages of tables, so the rows
+// are spread across the table, falling back to the first rows for small
+// tables and views, which cannot be sampled.
+func (p *postgresLoader) sampleQueries(table Table, rows int) []string {
+	if table.Kind == View {
+		return []string{limitQuery(p.quotedName(table), rows)}
 	}
+	return []string{
+		fmt.Sprintf("SELECT * FROM %v TABLESAMPLE SYSTEM (1) LIMIT %d;", p.quotedName(table), rows),
+		limitQuery(p.quotedName(table), rows),
+	}
+}
+
+// quotedName returns the schema-qualified name of a table, with each part
+// quoted so that names with mixed case or special characters are preserved.
+func (p *postgresLoader) quotedName(table Table) string {
+	return pq.QuoteIdentifier(table.Schema) + "." + pq.QuoteIdentifier(table.Name)
+}
 
-	var stringValues []string
-	for _, rvp := range values {
-		// Based on code from sqltocsv
-		rawValue := *rvp.(*interface{})
-		var value interface{}
-		byteArray, ok := rawValue.([]byte)
-		if ok {
-			value = string(byteArray)
-		} else {
-			value = rawValue
+func (p *postgresLoader) quote(identifier string) string {
+	return pq.QuoteIdentifier(identifier)
+}
+
+// fingerprint hashes the relations, columns and keys that would be loaded,
+// including their comments
+func (p *postgresLoader) fingerprint(db *sql.DB) (string, error) {
+	var fingerprint string
+	err := db.QueryRow(`
+		SELECT md5(concat(
+			(
+				SELECT string_agg(
+					concat_ws(
+						':', n.nspname, c.relname, c.relkind, obj_description(c.oid, 'pg_class'),
+						CASE WHEN c.relkind IN ('v', 'm') THEN md5(pg_get_viewdef(c.oid)) END
+					),
+					',' ORDER BY n.nspname, c.relname
+				)
+				FROM pg_catalog.pg_class c
+				JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+				WHERE `+postgresRelationFilter+`
+			),
+			(
+				SELECT string_agg(
+					concat_ws(':', c.oid, a.attname, format_type(a.atttypid, a.atttypmod), a.attnotnull, col_description(a.attrelid, a.attnum)),
+					',' ORDER BY c.oid, a.attnum
+				)
+				FROM pg_catalog.pg_attribute a
+				JOIN pg_catalog.pg_class c ON c.oid = a.attrelid
+				JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+				WHERE a.attnum > 0 AND NOT a.attisdropped AND `+postgresRelationFilter+`
+			),
+			(
+				SELECT string_agg(concat_ws(':', c.oid, pg_get_constraintdef(con.oid)), ',' ORDER BY con.oid)
+				FROM pg_catalog.pg_constraint con
+				JOIN pg_catalog.pg_class c ON c.oid = con.conrelid
+				JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+				WHERE con.contype IN ('p', 'f') AND `+postgresRelationFilter+`
+			),
+			(
+				-- Labels can be added to an enum without changing the
+				-- columns that use it
+				SELECT string_agg(concat_ws(':', e.enumtypid, e.enumlabel), ',' ORDER BY e.enumtypid, e.enumsortorder)
+				FROM pg_catalog.pg_enum e
+			)
+		))`,
+		pq.Array(p.schemas),
+	).Scan(&fingerprint)
+	if err != nil {
+		return "", fmt.Errorf("fingerprinting schema: %w", err)
+	}
+	return fingerprint, nil
+}
+
+// loadStatsProfiles profiles columns using the statistics gathered by
+// ANALYZE. Columns are only listed as having a fixed set of values if every
+// distinct value is among the most common values. The range of values is
+// taken from the bounds of the histogram, so may be approximate if the
+// statistics are out of date.
+func (p *postgresLoader) loadStatsProfiles(db *sql.DB, tables []Table, index tableIndex, maxValues int) error {
+	rows, err := db.Query(`
+		SELECT
+			n.nspname,
+			c.relname,
+			a.attname,
+			t.typcategory,
+			s.n_distinct,
+			COALESCE(s.most_common_vals::text::text[], '{}'),
+			COALESCE(s.histogram_bounds::text::text[], '{}')
+		FROM pg_catalog.pg_stats s
+		JOIN pg_catalog.pg_namespace n ON n.nspname = s.schemaname
+		JOIN pg_catalog.pg_class c ON c.relnamespace = n.oid AND c.relname = s.tablename
+		JOIN pg_catalog.pg_attribute a ON a.attrelid = c.oid AND a.attname = s.attname
+		JOIN pg_catalog.pg_type t ON t.oid = a.atttypid
+		WHERE t.typcategory != 'A'
+		-- Partitioned tables only have statistics including their partitions,
+		-- while other tables' own statistics exclude any child tables
+		AND s.inherited = (c.relkind = 'p')
+		AND `+postgresRelationFilter,
+		pq.Array(p.schemas),
+	)
+	if err != nil {
+		return fmt.Errorf("loading statistics: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var schemaName, tableName, columnName, category string
+		var distinct float64
+		var commonValues, histogram []sql.NullString
+		err := rows.Scan(&schemaName, &tableName, &columnName, &category, &distinct, pq.Array(&commonValues), pq.Array(&histogram))
+		if err != nil {
+			return fmt.Errorf("scanning statistics: %w", err)
+		}
+		table := index.find(tables, Table{Schema: schemaName, Name: tableName})
+		if table == nil {
+			continue
 		}
 
-		float64Value, ok := value.(float64)
-		if ok {
-			value = fmt.Sprintf("%v", float64Value)
-		} else {
-			float32Value, ok := value.(float32)
-			if ok {
-				value = fmt.Sprintf("%v", float32Value)
+		// Numbers are written without quotes
+		unquoted := category == "N"
+		profile := &ColumnProfile{}
+		switch category {
+		case "S", "E":
+			// A negative n_distinct is a fraction of the number of rows,
+			// so the number of distinct values is not known
+			if distinct > 0 && int(distinct) <= maxValues && len(commonValues) >= int(distinct) {
+				for _, value := range commonValues {
+					if value.Valid && len(value.String) <= maxProfileValueLength {
+						profile.Values = append(profile.Values, Value{Text: value.String})
+					}
+				}
+				sort.Slice(profile.Values, func(i, j int) bool {
+					return profile.Values[i].Text < profile.Values[j].Text
+				})
+			}
+		case "N", "D":
+			if len(histogram) > 1 && histogram[0].Valid && histogram[len(histogram)-1].Valid {
+				profile.Min = &Value{Text: histogram[0].String, Unquoted: unquoted}
+				profile.Max = &Value{Text: histogram[len(histogram)-1].String, Unquoted: unquoted}
 			}
 		}
 
-		timeValue, ok := value.(time.Time)
-		if ok {
-			value = timeValue.Format(time.RFC1123)
+		for i := range table.Columns {
+			if table.Columns[i].Name == columnName {
+				table.Columns[i].Profile = profile
+			}
 		}
+	}
+	return rows.Err()
+}
+
+// maxJSONKeys is the number of top-level keys listed for each JSON column
+const maxJSONKeys = 20
 
-		if value == nil {
-			stringValues = append(stringValues, "")
-		} else {
-			stringValues = append(stringValues, fmt.Sprintf("%v", value))
+// inspectTable loads the most common top-level keys of objects in the JSON
+// columns of a table, from up to 1000 rows.
+func (p *postgresLoader) inspectTable(ctx context.Context, db *sql.DB, table *Table) error {
+	if table.Kind == View {
+		return nil
+	}
+	for i, column := range table.Columns {
+		if column.Type != "json" && column.Type != "jsonb" {
+			continue
+		}
+		name := pq.QuoteIdentifier(column.Name)
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+			SELECT key FROM (
+				SELECT jsonb_object_keys(value) AS key FROM (
+					SELECT %[1]v::jsonb AS value FROM %[2]v
+					WHERE jsonb_typeof(%[1]v::jsonb) = 'object'
+					LIMIT 1000
+				) objects
+			) keys
+			GROUP BY key
+			ORDER BY COUNT(*) DESC, key
+			LIMIT %[3]d`,
+			name, p.quotedName(*table), maxJSONKeys,
+		))
+		if err != nil {
+			return fmt.Errorf("loading keys of %v: %w", column.Name, err)
 		}
+		var keys []string
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				rows.Close()
+				return fmt.Errorf("scanning keys of %v: %w", column.Name, err)
+			}
+			keys = append(keys, key)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("loading keys of %v: %w", column.Name, err)
+		}
+		table.Columns[i].JSONKeys = keys
 	}
-
-	return Table{
-		Name:      table,
-		Columns:   columns,
-		SampleRow: stringValues,
-	}, nil
+	return nil
 }
@@ -0,0 +1,273 @@
+package schema
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// profileSampleRows is the number of rows from which the distinct values of
+// large tables are listed, so profiling does not scan the whole table
+const profileSampleRows = 10000
+
+// maxProfileValueLength is the length of the longest value listed in a
+// column profile. Columns with longer values are assumed to contain free
+// text rather than a fixed set of values.
+const maxProfileValueLength = 64
+
+// ColumnProfile summarizes the values in a column
+type ColumnProfile struct {
+	// Values lists the distinct values of low-cardinality columns
+	Values []Value `json:"values,omitempty"`
+	// Sampled is true if the values were listed from a sample of the rows,
+	// so other values may exist
+	Sampled bool `json:"sampled,omitempty"`
+	// Min and Max are the range of values in numeric and date columns
+	Min *Value `json:"min,omitempty"`
+	Max *Value `json:"max,omitempty"`
+}
+
+// String describes the profile for use in a SQL comment
+func (p ColumnProfile) String() string {
+	var parts []string
+	if len(p.Values) > 0 {
+		var literals []string
+		for _, value := range p.Values {
+			literals = append(literals, value.Literal())
+		}
+		label := "values: "
+		if p.Sampled {
+			label = "values include: "
+		}
+		parts = append(parts, label+strings.Join(literals, ", "))
+	}
+	if p.Min != nil && p.Max != nil {
+		parts = append(parts, fmt.Sprintf("range: %v to %v", p.Min.Literal(), p.Max.Literal()))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// statsProfiler is implemented by loaders that can profile columns using
+// statistics maintained by the database, which is much cheaper than
+// querying each table. Columns profiled this way should have their Profile
+// set, even if it is empty, so they are not queried.
+type statsProfiler interface {
+	loadStatsProfiles(db *sql.DB, tables []Table, index tableIndex, maxValues int) error
+}
+
+// loadProfiles profiles the columns of all tables, listing the values of
+// text columns with at most maxValues distinct values, and the range of
+// numeric and date columns. Views are not queried, as they may be expensive
+// to evaluate.
+func loadProfiles(ctx context.Context, loader loader, db *sql.DB, tables []Table, maxValues, concurrency int, timeout time.Duration) {
+	if profiler, ok := loader.(statsProfiler); ok {
+		if err := profiler.loadStatsProfiles(db, tables, indexTables(tables), maxValues); err != nil {
+			log.Printf("Could not load column statistics: %v", err)
+		}
+	}
+
+	forEachTable(tables, concurrency, "Profiled", func(table *Table) {
+		if table.Kind == View {
+			return
+		}
+		if err := profileTable(ctx, loader, db, table, maxValues, timeout); err != nil {
+			log.Printf("Skipping profile for some columns of %v: %v", table.QualifiedName(), err)
+		}
+	})
+}
+
+// profileTable profiles the columns of a table that do not already have a
+// profile. Columns that cannot be profiled are left without a profile, and
+// their errors returned together.
+func profileTable(ctx context.Context, loader loader, db *sql.DB, table *Table, maxValues int, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var errs []error
+	var ranged []int
+	for i, column := range table.Columns {
+		if column.Profile != nil {
+			continue
+		}
+		switch {
+		case isTextType(column.Type):
+			profile, err := distinctValues(ctx, loader, db, *table, column, maxValues)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			table.Columns[i].Profile = profile
+		case isNumericType(column.Type) || isTemporalType(column.Type):
+			ranged = append(ranged, i)
+		}
+	}
+	if len(ranged) == 0 {
+		return errors.Join(errs...)
+	}
+
+	// The ranges of all columns are loaded in one query, and if that fails,
+	// one column at a time so a single failing column can be skipped
+	if err := loadRanges(ctx, loader, db, table, ranged); err != nil && len(ranged) > 1 {
+		for _, i := range ranged {
+			if err := loadRanges(ctx, loader, db, table, []int{i}); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	} else if err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// loadRanges sets the profiles of the columns at the given positions to
+// their minimum and maximum values
+func loadRanges(ctx context.Context, loader loader, db *sql.DB, table *Table, columns []int) error {
+	var aggregates, types, names []string
+	for _, i := range columns {
+		name := loader.quote(table.Columns[i].Name)
+		aggregates = append(aggregates, fmt.Sprintf("MIN(%v), MAX(%v)", name, name))
+		types = append(types, table.Columns[i].Type, table.Columns[i].Type)
+		names = append(names, table.Columns[i].Name)
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT %v FROM %v;", strings.Join(aggregates, ", "), loader.quotedName(*table)))
+	if err != nil {
+		return fmt.Errorf("loading ranges of %v: %w", strings.Join(names, ", "), err)
+	}
+	defer rows.Close()
+	values, err := scanValues(rows, types)
+	if err != nil {
+		return fmt.Errorf("loading ranges of %v: %w", strings.Join(names, ", "), err)
+	}
+	for j, i := range columns {
+		if len(values) == 0 {
+			break
+		}
+		profile := &ColumnProfile{}
+		if min, max := values[0][2*j], values[0][2*j+1]; !min.Null && !max.Null {
+			profile.Min, profile.Max = &min, &max
+		}
+		table.Columns[i].Profile = profile
+	}
+	return nil
+}
+
+// distinctValues returns a profile listing the distinct values of a column,
+// with no values if there are more than maxValues or they are too long to be
+// useful. Tables that are not known to be small are sampled using the
+// loader's sample queries, so the whole table is not scanned.
+func distinctValues(ctx context.Context, loader loader, db *sql.DB, table Table, column Column, maxValues int) (*ColumnProfile, error) {
+	name := loader.quote(column.Name)
+	var sources []string
+	sampled := table.Stats == nil || table.Stats.RowCount == nil || *table.Stats.RowCount > profileSampleRows
+	if sampled {
+		for _, query := range loader.sampleQueries(table, profileSampleRows) {
+			sources = append(sources, fmt.Sprintf("(%v) sampled", strings.TrimSuffix(strings.TrimSpace(query), ";")))
+		}
+	} else {
+		sources = []string{loader.quotedName(table)}
+	}
+
+	// As for sample rows, each source is tried in turn until one has values
+	var values [][]Value
+	for _, source := range sources {
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(
+			"SELECT DISTINCT %v FROM %v WHERE %v IS NOT NULL LIMIT %d;",
+			name, source, name, maxValues+1,
+		))
+		if err != nil {
+			return nil, fmt.Errorf("loading values of %v: %w", column.Name, err)
+		}
+		values, err = scanValues(rows, []string{column.Type})
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("loading values of %v: %w", column.Name, err)
+		}
+		if len(values) > 0 {
+			break
+		}
+	}
+	if len(values) > maxValues {
+		return &ColumnProfile{}, nil
+	}
+
+	profile := &ColumnProfile{Sampled: sampled && len(values) > 0}
+	for _, row := range values {
+		if len(row[0].Text) > maxProfileValueLength {
+			return &ColumnProfile{}, nil
+		}
+		profile.Values = append(profile.Values, row[0])
+	}
+	sort.Slice(profile.Values, func(i, j int) bool {
+		return profile.Values[i].Text < profile.Values[j].Text
+	})
+	return profile, nil
+}
+
+// scanValues returns the values of all rows. Where the driver does not
+// report the type of a result column, the type at the same position in
+// types is assumed.
+func scanValues(rows *sql.Rows, types []string) ([][]Value, error) {
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	var out [][]Value
+	for rows.Next() {
+		values := make([]interface{}, len(columnTypes))
+		pointers := make([]interface{}, len(columnTypes))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		var row []Value
+		for i, value := range values {
+			databaseType := columnTypes[i].DatabaseTypeName()
+			if databaseType == "" && i < len(types) {
+				databaseType = types[i]
+			}
+			row = append(row, newValue(value, databaseType))
+		}
+		out = append(out, row)
+	}
+	return out, rows.Err()
+}
+
+// isTextType returns true if a database type name refers to a text type.
+// Arrays of text are not text.
+func isTextType(databaseType string) bool {
+	if isArrayType(databaseType) {
+		return false
+	}
+	databaseType = strings.ToUpper(databaseType)
+	for _, text := range []string{"CHAR", "TEXT", "STRING", "ENUM"} {
+		if strings.Contains(databaseType, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// isArrayType returns true if a database type name refers to an array, as in
+// text[] or VARCHAR[3] for Postgres and DuckDB, or ARRAY for Snowflake and
+// information schemas
+func isArrayType(databaseType string) bool {
+	databaseType = baseType(databaseType)
+	return strings.HasSuffix(databaseType, "]") || databaseType == "ARRAY" || strings.HasSuffix(databaseType, " ARRAY")
+}
+
+// isTemporalType returns true if a database type name refers to a date or
+// time type. Arrays of dates and times are not temporal.
+func isTemporalType(databaseType string) bool {
+	if isArrayType(databaseType) {
+		return false
+	}
+	databaseType = strings.ToUpper(databaseType)
+	return strings.Contains(databaseType, "DATE") || strings.Contains(databaseType, "TIME")
+}
@@ -0,0 +1,436 @@
+package schema
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// RedactionPolicy determines how sample values are redacted before they are
+// included in the schema
+type RedactionPolicy string
+
+const (
+	// RedactKeep leaves values unchanged
+	RedactKeep RedactionPolicy = "keep"
+	// RedactMask replaces values with a placeholder such as [REDACTED EMAIL]
+	RedactMask RedactionPolicy = "mask"
+	// RedactSynthesize replaces values with fake values of the same format
+	RedactSynthesize RedactionPolicy = "synthesize"
+	// RedactOmit removes values entirely
+	RedactOmit RedactionPolicy = "omit"
+)
+
+// ParseRedactionPolicy returns the policy with the given name
+func ParseRedactionPolicy(name string) (RedactionPolicy, error) {
+	switch policy := RedactionPolicy(strings.ToLower(strings.TrimSpace(name))); policy {
+	case RedactKeep, RedactMask, RedactSynthesize, RedactOmit:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown redaction policy %q", name)
+	}
+}
+
+// RedactionRule applies a redaction policy to the sample values of columns
+// matching a pattern. Patterns are interpreted as for WithColumnFilter.
+type RedactionRule struct {
+	Columns string
+	Policy  RedactionPolicy
+}
+
+type redactionRule struct {
+	columns namePattern
+	policy  RedactionPolicy
+}
+
+// redactor redacts sample values using rules for specific columns, and
+// detectors for personal data in any other column.
+type redactor struct {
+	rules          []redactionRule
+	detectedPolicy RedactionPolicy
+}
+
+func newRedactor(rules []RedactionRule, detectedPolicy RedactionPolicy) (redactor, error) {
+	r := redactor{detectedPolicy: detectedPolicy}
+	for _, rule := range rules {
+		columns, err := parseNamePattern(rule.Columns)
+		if err != nil {
+			return redactor{}, err
+		}
+		if _, err := ParseRedactionPolicy(string(rule.Policy)); err != nil {
+			return redactor{}, err
+		}
+		r.rules = append(r.rules, redactionRule{columns: columns, policy: rule.Policy})
+	}
+	return r, nil
+}
+
+// redactTables redacts the sample rows of all tables in place, returning
+// the number of values redacted
+func (r redactor) redactTables(tables []Table) int {
+	var redacted int
+	for t := range tables {
+		table := &tables[t]
+		for _, row := range table.SampleRows {
+			for i, value := range row {
+				if i >= len(table.Columns) || value.Null {
+					continue
+				}
+				out := r.redact(table.QualifiedName()+"."+table.Columns[i].Name, value)
+				if out != value {
+					row[i] = out
+					redacted++
+				}
+			}
+		}
+		for c, column := range table.Columns {
+			var keys []string
+			for _, key := range column.JSONKeys {
+				if !containsPersonalData(key) {
+					keys = append(keys, key)
+				} else {
+					redacted++
+				}
+			}
+			table.Columns[c].JSONKeys = keys
+			var fields []NestedField
+			for _, field := range column.NestedFields {
+				if !containsPersonalData(field.Path) {
+					fields = append(fields, field)
+				} else {
+					redacted++
+				}
+			}
+			table.Columns[c].NestedFields = fields
+			if column.Profile != nil {
+				redacted += r.redactProfile(table.QualifiedName()+"."+column.Name, column.Profile)
+			}
+		}
+	}
+	return redacted
+}
+
+// redactProfile removes values from a column profile that would be
+// redacted, returning the number of values removed. Redacted values are not
+// replaced, as a list of masked or synthesized values would mislead the
+// model about the values it can filter on.
+func (r redactor) redactProfile(column string, profile *ColumnProfile) int {
+	var redacted int
+	for _, value := range profile.Values {
+		if r.redact(column, value) != value {
+			redacted = len(profile.Values)
+			profile.Values = nil
+			break
+		}
+	}
+	if profile.Min != nil && profile.Max != nil {
+		if r.redact(column, *profile.Min) != *profile.Min || r.redact(column, *profile.Max) != *profile.Max {
+			redacted += 2
+			profile.Min, profile.Max = nil, nil
+		}
+	}
+	return redacted
+}
+
+// redact applies the policy for the first rule matching the column to the
+// whole value, or if none match, applies the policy for detected personal
+// data to each part of the value containing it.
+func (r redactor) redact(column string, value Value) Value {
+	for _, rule := range r.rules {
+		if rule.columns.matches(column) {
+			return applyRedaction(rule.policy, value, detect(value.Text))
+		}
+	}
+	spans := findPersonalData(value.Text)
+	if len(spans) == 0 {
+		return value
+	}
+	switch r.detectedPolicy {
+	case RedactMask, RedactSynthesize:
+		var out strings.Builder
+		var last int
+		for _, span := range spans {
+			out.WriteString(value.Text[last:span.start])
+			part := applyRedaction(r.detectedPolicy, Value{Text: value.Text[span.start:span.end]}, span.detector)
+			out.WriteString(part.Text)
+			last = span.end
+		}
+		out.WriteString(value.Text[last:])
+		value.Text = out.String()
+		// Redacted numbers remain numbers, but other text must be quoted
+		if _, err := strconv.ParseFloat(value.Text, 64); err != nil {
+			value.Unquoted = false
+		}
+		return value
+	default:
+		return applyRedaction(r.detectedPolicy, value, spans[0].detector)
+	}
+}
+
+func applyRedaction(policy RedactionPolicy, value Value, d *detector) Value {
+	switch policy {
+	case RedactOmit:
+		return Value{Null: true}
+	case RedactMask:
+		if d != nil {
+			return Value{Text: fmt.Sprintf("[REDACTED %v]", strings.ToUpper(d.name))}
+		}
+		return Value{Text: "[REDACTED]"}
+	case RedactSynthesize:
+		if d != nil {
+			value.Text = d.synthesize(value.Text)
+		} else {
+			value.Text = synthesizeText(value.Text)
+		}
+		// Synthesized numbers remain numbers, but other text must be quoted
+		if _, err := strconv.ParseFloat(value.Text, 64); err != nil {
+			value.Unquoted = false
+		}
+		return value
+	default:
+		return value
+	}
+}
+
+// detector identifies and synthesizes a kind of personal data
+type detector struct {
+	name    string
+	pattern *regexp.Regexp
+	// valid rejects matches of the pattern that are not personal data, if set
+	valid      func(match string) bool
+	synthesize func(value string) string
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)
+	ssnPattern   = regexp.MustCompile(`\d{3}-\d{2}-\d{4}`)
+	cardPattern  = regexp.MustCompile(`\d(?:[ -]?\d){12,18}`)
+	phonePattern = regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{2,4}\)|\d{2,4})[ .-]?\d{3,4}[ .-]?\d{3,4}`)
+	ipv4Pattern  = regexp.MustCompile(`(?:\d{1,3}\.){3}\d{1,3}`)
+	ipv6Pattern  = regexp.MustCompile(`[0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7}`)
+	// addressPattern matches a street address such as "12 Main St" or
+	// "221B Baker Street, Apt 4", where the street name is capitalized
+	addressPattern = regexp.MustCompile(`\d{1,6}[A-Za-z]?(?: +[A-Z][A-Za-z'-]*\.?){1,4} +(?:` + streetSuffixes + `)\b\.?(?:,? *(?:Apt|Apartment|Suite|Ste|Unit|#)\.? *#?[A-Za-z0-9-]+)?`)
+)
+
+// streetSuffixes are the words, in their common forms and abbreviations,
+// that end the street part of an address
+const streetSuffixes = `Street|St|Avenue|Ave|Road|Rd|Boulevard|Blvd|Lane|Ln|Drive|Dr|Court|Ct|` +
+	`Place|Pl|Terrace|Way|Circle|Cir|Highway|Hwy|Parkway|Pkwy|Square|Sq|` +
+	`STREET|ST|AVENUE|AVE|ROAD|RD|BOULEVARD|BLVD|LANE|LN|DRIVE|DR|COURT|CT|WAY`
+
+// detectors are checked in order, so more specific formats come first
+var detectors = []detector{
+	{
+		name:    "email",
+		pattern: emailPattern,
+		synthesize: func(string) string {
+			return "user@example.com"
+		},
+	},
+	{
+		name:    "address",
+		pattern: addressPattern,
+		synthesize: func(string) string {
+			return "123 Example Street"
+		},
+	},
+	{
+		name:    "ssn",
+		pattern: ssnPattern,
+		synthesize: func(value string) string {
+			return replaceDigits(value, "000000000")
+		},
+	},
+	{
+		name:    "card number",
+		pattern: cardPattern,
+		valid:   isCardNumber,
+		synthesize: func(value string) string {
+			// A well known test card number
+			return replaceDigits(value, "4111111111111111111")
+		},
+	},
+	{
+		name:    "phone number",
+		pattern: phonePattern,
+		valid:   isPhoneNumber,
+		synthesize: func(value string) string {
+			return replaceDigits(value, "555555555555555")
+		},
+	},
+	{
+		name:    "ip address",
+		pattern: ipv4Pattern,
+		valid:   isIPAddress,
+		synthesize: func(string) string {
+			// An address reserved for documentation
+			return "192.0.2.1"
+		},
+	},
+	{
+		name:    "ip address",
+		pattern: ipv6Pattern,
+		valid:   isIPAddress,
+		synthesize: func(string) string {
+			// An address reserved for documentation
+			return "2001:db8::1"
+		},
+	},
+}
+
+// personalData is the position of personal data within a value
+type personalData struct {
+	start, end int
+	detector   *detector
+}
+
+// findPersonalData returns the parts of value that appear to contain
+// personal data, in order. Where matches overlap, the detector checked first
+// is used.
+func findPersonalData(value string) []personalData {
+	var spans []personalData
+	for d := range detectors {
+		detector := &detectors[d]
+		for _, loc := range detector.pattern.FindAllStringIndex(value, -1) {
+			start, end := loc[0], loc[1]
+			// Matches must not start or end within a word or number, or be
+			// part of a longer sequence of numbers
+			if inWord(value, start-1) || inWord(value, end) || inNumbers(value, start, end) {
+				continue
+			}
+			if detector.valid != nil && !detector.valid(value[start:end]) {
+				continue
+			}
+			if overlaps(spans, start, end) {
+				continue
+			}
+			spans = append(spans, personalData{start: start, end: end, detector: detector})
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].start < spans[j].start
+	})
+	return spans
+}
+
+// detect returns the detector matching the whole value, or nil if the value
+// is not entirely personal data
+func detect(value string) *detector {
+	value = strings.TrimSpace(value)
+	spans := findPersonalData(value)
+	if len(spans) == 1 && spans[0].start == 0 && spans[0].end == len(value) {
+		return spans[0].detector
+	}
+	return nil
+}
+
+// containsPersonalData returns true if any part of value appears to be
+// personal data
+func containsPersonalData(value string) bool {
+	return len(findPersonalData(value)) > 0
+}
+
+func overlaps(spans []personalData, start, end int) bool {
+	for _, span := range spans {
+		if start < span.end && span.start < end {
+			return true
+		}
+	}
+	return false
+}
+
+// inWord returns true if the byte at i is part of a word or number
+func inWord(value string, i int) bool {
+	if i < 0 || i >= len(value) {
+		return false
+	}
+	return isWordByte(value[i]) || isDigit(value[i])
+}
+
+// inNumbers returns true if the text from start to end is preceded or
+// followed by a separator and another number, as in "1234 5678 9012 3456"
+func inNumbers(value string, start, end int) bool {
+	separator := func(i int) bool {
+		return i >= 0 && i < len(value) && strings.IndexByte(" .-", value[i]) >= 0
+	}
+	return separator(start-1) && start >= 2 && isDigit(value[start-2]) ||
+		separator(end) && end+1 < len(value) && isDigit(value[end+1])
+}
+
+// isCardNumber returns true for 13 to 19 digit numbers, optionally
+// separated by spaces or dashes, that pass the Luhn check
+func isCardNumber(value string) bool {
+	var digits []int
+	for _, r := range value {
+		if unicode.IsDigit(r) {
+			digits = append(digits, int(r-'0'))
+		}
+	}
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	var sum int
+	for i := range digits {
+		digit := digits[len(digits)-1-i]
+		if i%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
+
+// isPhoneNumber returns true for numbers formatted as phone numbers. A
+// separator or leading + is required, and decimal numbers are excluded, so
+// that numeric values are not mistaken for phone numbers.
+func isPhoneNumber(value string) bool {
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return false
+	}
+	return strings.ContainsAny(value, "+-.() ")
+}
+
+func isIPAddress(value string) bool {
+	return net.ParseIP(value) != nil
+}
+
+// replaceDigits replaces the digits in value with those from replacement,
+// preserving any formatting
+func replaceDigits(value, replacement string) string {
+	var out strings.Builder
+	var i int
+	for _, r := range value {
+		if unicode.IsDigit(r) && i < len(replacement) {
+			out.WriteByte(replacement[i])
+			i++
+			continue
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+// synthesizeText replaces letters and digits with placeholders, preserving
+// case and punctuation
+func synthesizeText(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsUpper(r):
+			return 'X'
+		case unicode.IsLetter(r):
+			return 'x'
+		case unicode.IsDigit(r):
+			return '0'
+		default:
+			return r
+		}
+	}, value)
+}
@@ -0,0 +1,222 @@
+package schema
+
+import "testing"
+
+func TestRedactDetected(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		mask  string
+		// synthesize is the expected synthesized value, if it differs from
+		// the value
+		synthesize string
+	}{
+		{
+			name:       "email",
+			value:      "jane.doe@example.org",
+			mask:       "[REDACTED EMAIL]",
+			synthesize: "user@example.com",
+		},
+		{
+			name:       "ssn",
+			value:      "123-45-6789",
+			mask:       "[REDACTED SSN]",
+			synthesize: "000-00-0000",
+		},
+		{
+			name:       "card number",
+			value:      "4242 4242 4242 4242",
+			mask:       "[REDACTED CARD NUMBER]",
+			synthesize: "4111 1111 1111 1111",
+		},
+		{
+			name:       "phone number",
+			value:      "+1 (415) 555-0132",
+			mask:       "[REDACTED PHONE NUMBER]",
+			synthesize: "+5 (555) 555-5555",
+		},
+		{
+			name:       "ipv4 address",
+			value:      "10.1.2.3",
+			mask:       "[REDACTED IP ADDRESS]",
+			synthesize: "192.0.2.1",
+		},
+		{
+			name:       "ipv6 address",
+			value:      "fe80::1ff:fe23:4567:890a",
+			mask:       "[REDACTED IP ADDRESS]",
+			synthesize: "2001:db8::1",
+		},
+		{
+			name:       "address",
+			value:      "221B Baker Street, Apt 4",
+			mask:       "[REDACTED ADDRESS]",
+			synthesize: "123 Example Street",
+		},
+		{
+			name:       "abbreviated address",
+			value:      "1600 Pennsylvania Ave. NW",
+			mask:       "[REDACTED ADDRESS] NW",
+			synthesize: "123 Example Street NW",
+		},
+		{
+			name:       "email in text",
+			value:      "Customer asked to be contacted at jane@example.org after 5pm",
+			mask:       "Customer asked to be contacted at [REDACTED EMAIL] after 5pm",
+			synthesize: "Customer asked to be contacted at user@example.com after 5pm",
+		},
+		{
+			name:       "phone number in text",
+			value:      "Call 415-555-0132 to confirm.",
+			mask:       "Call [REDACTED PHONE NUMBER] to confirm.",
+			synthesize: "Call 555-555-5555 to confirm.",
+		},
+		{
+			name:       "address in text",
+			value:      "Deliver to 12 Main St, leave at door",
+			mask:       "Deliver to [REDACTED ADDRESS], leave at door",
+			synthesize: "Deliver to 123 Example Street, leave at door",
+		},
+		{
+			name:       "several kinds in text",
+			value:      "jane@example.org paid with 4242-4242-4242-4242",
+			mask:       "[REDACTED EMAIL] paid with [REDACTED CARD NUMBER]",
+			synthesize: "user@example.com paid with 4111-1111-1111-1111",
+		},
+		{
+			name:  "integer",
+			value: "4155550132",
+			mask:  "4155550132",
+		},
+		{
+			name:  "decimal",
+			value: "1234567.89",
+			mask:  "1234567.89",
+		},
+		{
+			name:  "date",
+			value: "2023-01-15 10:30:00",
+			mask:  "2023-01-15 10:30:00",
+		},
+		{
+			name:  "card number failing the Luhn check",
+			value: "4242 4242 4242 4241",
+			mask:  "4242 4242 4242 4241",
+		},
+		{
+			name:  "number followed by lower case words",
+			value: "Walked 3 miles down the road",
+			mask:  "Walked 3 miles down the road",
+		},
+		{
+			name:  "number within an identifier",
+			value: "order-123-45-6789x",
+			mask:  "order-123-45-6789x",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			synthesize := test.synthesize
+			if synthesize == "" {
+				synthesize = test.value
+			}
+			for policy, want := range map[RedactionPolicy]string{
+				RedactMask:       test.mask,
+				RedactSynthesize: synthesize,
+				RedactKeep:       test.value,
+			} {
+				r, err := newRedactor(nil, policy)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := r.redact("t.c", Value{Text: test.value})
+				if got.Null || got.Text != want {
+					t.Errorf("%v: got %q, want %q", policy, got.Text, want)
+				}
+			}
+
+			r, err := newRedactor(nil, RedactOmit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := r.redact("t.c", Value{Text: test.value})
+			if omitted := test.mask != test.value; got.Null != omitted {
+				t.Errorf("omit: got %+v, expected omitted %v", got, omitted)
+			}
+		})
+	}
+}
+
+func TestRedactColumns(t *testing.T) {
+	r, err := newRedactor([]RedactionRule{
+		{Columns: "users.name", Policy: RedactMask},
+		{Columns: "users.email", Policy: RedactKeep},
+		{Columns: "users.notes", Policy: RedactOmit},
+	}, RedactMask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		column string
+		value  Value
+		want   Value
+	}{
+		{
+			column: "users.name",
+			value:  Value{Text: "Jane Doe"},
+			want:   Value{Text: "[REDACTED]"},
+		},
+		{
+			column: "users.email",
+			value:  Value{Text: "jane@example.org"},
+			want:   Value{Text: "jane@example.org"},
+		},
+		{
+			column: "users.notes",
+			value:  Value{Text: "prefers mornings"},
+			want:   Value{Null: true},
+		},
+		{
+			column: "users.bio",
+			value:  Value{Text: "Email jane@example.org"},
+			want:   Value{Text: "Email [REDACTED EMAIL]"},
+		},
+		{
+			column: "users.id",
+			value:  Value{Text: "42", Unquoted: true},
+			want:   Value{Text: "42", Unquoted: true},
+		},
+	}
+	for _, test := range tests {
+		if got := r.redact(test.column, test.value); got != test.want {
+			t.Errorf("%v: got %+v, want %+v", test.column, got, test.want)
+		}
+	}
+}
+
+func TestRedactTables(t *testing.T) {
+	r, err := newRedactor(nil, RedactMask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := []Table{{
+		Name: "users",
+		Columns: []Column{
+			{Name: "id"},
+			{Name: "notes", JSONKeys: []string{"theme", "jane@example.org"}},
+		},
+		SampleRows: [][]Value{
+			{{Text: "1", Unquoted: true}, {Text: "Moved to 12 Main St"}},
+			{{Text: "2", Unquoted: true}, {Null: true}},
+		},
+	}}
+	if redacted := r.redactTables(tables); redacted != 2 {
+		t.Errorf("expected 2 values redacted, got %v", redacted)
+	}
+	if got := tables[0].SampleRows[0][1].Text; got != "Moved to [REDACTED ADDRESS]" {
+		t.Errorf("unexpected sample value %q", got)
+	}
+	if keys := tables[0].Columns[1].JSONKeys; len(keys) != 1 || keys[0] != "theme" {
+		t.Errorf("unexpected JSON keys %v", keys)
+	}
+}
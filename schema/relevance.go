@@ -0,0 +1,310 @@
+package schema
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// TokenCounter counts the tokens in text, as used by a language model
+type TokenCounter func(text string) int
+
+// TokenCounts are the sizes in tokens of the tables and glossary terms of a
+// schema, as written by Schema.String. They are counted once for each
+// schema, so that tables can be selected for each question without counting
+// them again.
+type TokenCounts struct {
+	// tables includes the separator before each table
+	tables []int
+	// glossary includes the line break before each term
+	glossary []int
+	// glossaryHeader is the line introducing the glossary
+	glossaryHeader int
+}
+
+// CountTokens counts the tokens in each table and glossary term of the
+// schema using countTokens
+func (s Schema) CountTokens(countTokens TokenCounter) TokenCounts {
+	counts := TokenCounts{
+		tables:   make([]int, len(s.Tables)),
+		glossary: make([]int, len(s.Glossary)),
+	}
+	separator := countTokens("\n\n")
+	for i, table := range s.Tables {
+		counts.tables[i] = countTokens(table.String()) + separator
+	}
+	if len(s.Glossary) > 0 {
+		counts.glossaryHeader = countTokens(glossaryHeader) + separator
+	}
+	for i, term := range s.Glossary {
+		counts.glossary[i] = countTokens("\n-- " + term.String())
+	}
+	return counts
+}
+
+// total returns the size of the whole schema
+func (c TokenCounts) total() int {
+	total := c.glossaryHeader
+	for _, tokens := range c.tables {
+		total += tokens
+	}
+	for _, tokens := range c.glossary {
+		total += tokens
+	}
+	return total
+}
+
+// Relevant returns the tables most relevant to a question, within a budget
+// of maxTokens tokens when the schema is written as a string, using the
+// sizes in counts, which must have been counted for this schema by
+// CountTokens. If the whole schema fits within the budget, or counts are for
+// a different number of tables, it is returned unchanged.
+//
+// Tables are ranked by the words they share with the question, in their
+// names, synonyms, columns, comments, and the glossary terms that refer to
+// them. Words that appear in fewer tables count for more. Tables related by
+// foreign keys to a relevant table are ranked higher, so the tables needed
+// to join them are likely to be included. Tables are returned in their
+// original order, along with the glossary terms that refer to them.
+func (s Schema) Relevant(question string, maxTokens int, counts TokenCounts) Schema {
+	if maxTokens <= 0 || len(counts.tables) != len(s.Tables) || counts.total() <= maxTokens {
+		return s
+	}
+
+	scores := s.relevanceScores(question)
+	ranked := make([]int, len(s.Tables))
+	for i := range ranked {
+		ranked[i] = i
+	}
+	sort.SliceStable(ranked, func(a, b int) bool {
+		return scores[ranked[a]] > scores[ranked[b]]
+	})
+
+	// Glossary terms that refer to no table are always included, and others
+	// are counted with the first selected table they refer to
+	budget := maxTokens - counts.glossaryHeader
+	included := make([]bool, len(s.Glossary))
+	for j, term := range s.Glossary {
+		if len(term.References) == 0 {
+			included[j] = true
+			budget -= counts.glossary[j]
+		}
+	}
+	selected := make([]bool, len(s.Tables))
+	for _, i := range ranked {
+		tokens := counts.tables[i]
+		var terms []int
+		for j, term := range s.Glossary {
+			if !included[j] && referencesTable(term, s.Tables[i].QualifiedName()) {
+				tokens += counts.glossary[j]
+				terms = append(terms, j)
+			}
+		}
+		// Tables too large for the remaining budget are skipped, as smaller,
+		// less relevant tables may still fit
+		if tokens > budget {
+			continue
+		}
+		selected[i] = true
+		budget -= tokens
+		for _, j := range terms {
+			included[j] = true
+		}
+	}
+	return s.subset(selected)
+}
+
+// subset returns the selected tables, and the glossary terms that refer to
+// them
+func (s Schema) subset(selected []bool) Schema {
+	out := Schema{Dialect: s.Dialect}
+	names := make(map[string]bool)
+	for i, table := range s.Tables {
+		if selected[i] {
+			out.Tables = append(out.Tables, table)
+			names[table.QualifiedName()] = true
+		}
+	}
+	for _, term := range s.Glossary {
+		if len(term.References) == 0 || referencesAny(term, names) {
+			out.Glossary = append(out.Glossary, term)
+		}
+	}
+	return out
+}
+
+// relevanceScores scores each table against the words of the question
+func (s Schema) relevanceScores(question string) []float64 {
+	documents := make([]termWeights, len(s.Tables))
+	for i, table := range s.Tables {
+		documents[i] = table.termWeights()
+	}
+	for _, term := range s.Glossary {
+		for i, table := range s.Tables {
+			if !referencesTable(term, table.QualifiedName()) {
+				continue
+			}
+			documents[i].add(term.Term, 3)
+			for _, synonym := range term.Synonyms {
+				documents[i].add(synonym, 3)
+			}
+			documents[i].add(term.Definition, 1)
+		}
+	}
+
+	// Words found in fewer tables are weighted more heavily
+	frequency := make(map[string]int)
+	for _, document := range documents {
+		for word := range document {
+			frequency[word]++
+		}
+	}
+
+	scores := make([]float64, len(s.Tables))
+	words := make(map[string]bool)
+	for _, word := range searchWords(question) {
+		words[word] = true
+	}
+	for i, document := range documents {
+		for word := range words {
+			if weight, ok := document[word]; ok {
+				scores[i] += weight * math.Log(1+float64(len(s.Tables))/float64(frequency[word]))
+			}
+		}
+	}
+
+	// Tables are boosted by half the score of their most relevant neighbor
+	// through foreign keys in either direction
+	boosts := make([]float64, len(s.Tables))
+	index := indexTables(s.Tables)
+	for i, table := range s.Tables {
+		for _, foreignKey := range table.ForeignKeys {
+			j, ok := index[foreignKey.ReferencedTable]
+			if !ok {
+				continue
+			}
+			boosts[i] = math.Max(boosts[i], scores[j]/2)
+			boosts[j] = math.Max(boosts[j], scores[i]/2)
+		}
+	}
+	for i := range scores {
+		scores[i] += boosts[i]
+	}
+	return scores
+}
+
+// termWeights maps words to how strongly they indicate a table is relevant
+type termWeights map[string]float64
+
+func (t termWeights) add(text string, weight float64) {
+	for _, word := range searchWords(text) {
+		if weight > t[word] {
+			t[word] = weight
+		}
+	}
+}
+
+// termWeights returns the words describing the table. Names and synonyms
+// are weighted above column names, which are weighted above comments and
+// values.
+func (t Table) termWeights() termWeights {
+	terms := make(termWeights)
+	terms.add(t.Name, 4)
+	for _, synonym := range t.Synonyms {
+		terms.add(synonym, 4)
+	}
+	terms.add(t.Comment, 1)
+	for _, column := range t.Columns {
+		terms.add(column.Name, 2)
+		for _, synonym := range column.Synonyms {
+			terms.add(synonym, 2)
+		}
+		terms.add(column.Comment, 1)
+		for _, value := range column.EnumValues {
+			terms.add(value, 1)
+		}
+		if column.Profile != nil {
+			for _, value := range column.Profile.Values {
+				terms.add(value.Text, 1)
+			}
+		}
+	}
+	return terms
+}
+
+func referencesTable(term GlossaryTerm, table string) bool {
+	for _, reference := range term.References {
+		if reference == table || strings.HasPrefix(reference, table+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func referencesAny(term GlossaryTerm, tables map[string]bool) bool {
+	for table := range tables {
+		if referencesTable(term, table) {
+			return true
+		}
+	}
+	return false
+}
+
+// stopWords are common words in questions that do not identify tables
+var stopWords = map[string]bool{
+	"a": true, "all": true, "an": true, "and": true, "any": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "did": true, "do": true, "does": true, "each": true, "for": true,
+	"from": true, "get": true, "had": true, "has": true, "have": true, "how": true, "i": true,
+	"in": true, "is": true, "it": true, "list": true, "many": true, "me": true, "much": true,
+	"my": true, "of": true, "on": true, "or": true, "show": true, "that": true, "the": true,
+	"their": true, "there": true, "they": true, "this": true, "to": true, "was": true, "we": true,
+	"were": true, "what": true, "when": true, "where": true, "which": true, "who": true,
+	"with": true,
+}
+
+// searchWords splits text into lower case words for matching, splitting
+// identifiers such as order_items and orderItems into their words, and
+// removing plurals so that "orders" matches "order".
+func searchWords(text string) []string {
+	var words []string
+	var word []rune
+	flush := func() {
+		if len(word) == 0 {
+			return
+		}
+		w := stem(strings.ToLower(string(word)))
+		if !stopWords[w] {
+			words = append(words, w)
+		}
+		word = word[:0]
+	}
+	runes := []rune(text)
+	for i, r := range runes {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			flush()
+			continue
+		case unicode.IsUpper(r) && i > 0 && unicode.IsLower(runes[i-1]):
+			flush()
+		}
+		word = append(word, r)
+	}
+	flush()
+	return words
+}
+
+// stem removes common plural endings from a lower case word
+func stem(word string) string {
+	switch {
+	case len(word) > 4 && strings.HasSuffix(word, "ies"):
+		return word[:len(word)-3] + "y"
+	case len(word) > 4 && (strings.HasSuffix(word, "sses") || strings.HasSuffix(word, "uses") ||
+		strings.HasSuffix(word, "xes") || strings.HasSuffix(word, "ches") || strings.HasSuffix(word, "shes")):
+		return word[:len(word)-2]
+	case len(word) > 3 && strings.HasSuffix(word, "s") &&
+		!strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is"):
+		return word[:len(word)-1]
+	}
+	return word
+}
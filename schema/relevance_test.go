@@ -0,0 +1,156 @@
+package schema
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRelevant(t *testing.T) {
+	s := Schema{
+		Tables: []Table{
+			{Name: "products", Columns: []Column{{Name: "id"}, {Name: "title"}, {Name: "price"}}},
+			{Name: "customers", Columns: []Column{{Name: "id"}, {Name: "email"}}},
+			{
+				Name:        "orders",
+				Columns:     []Column{{Name: "id"}, {Name: "customer_id"}, {Name: "placed_at"}},
+				ForeignKeys: []ForeignKey{{Columns: []string{"customer_id"}, ReferencedTable: "customers"}},
+			},
+			{
+				Name:     "shipments",
+				Synonyms: []string{"deliveries"},
+				Comment:  "Every parcel sent to a customer, with the carrier and tracking details provided when it was dispatched",
+				Columns: []Column{
+					{Name: "id"}, {Name: "order_id"}, {Name: "carrier"}, {Name: "tracking_number"},
+					{Name: "shipped_at"}, {Name: "delivered_at"}, {Name: "delivery_address"},
+				},
+			},
+		},
+		Glossary: []GlossaryTerm{
+			{Term: "fiscal year", Definition: "Starts in April"},
+			{Term: "big spender", Definition: "A customer with orders over $1000", References: []string{"customers"}},
+		},
+	}
+	// Each word counts as a token
+	countTokens := func(text string) int {
+		return len(strings.Fields(text))
+	}
+	counts := s.CountTokens(countTokens)
+	// size returns the tokens needed for the named tables, and the glossary
+	// terms that refer to them
+	size := func(names ...string) int {
+		selected := make([]bool, len(s.Tables))
+		for i, table := range s.Tables {
+			for _, name := range names {
+				selected[i] = selected[i] || table.Name == name
+			}
+		}
+		return countTokens(s.subset(selected).String())
+	}
+
+	tests := []struct {
+		name      string
+		question  string
+		maxTokens int
+		want      []string
+	}{
+		{
+			name:      "whole schema fits",
+			question:  "How many products are there?",
+			maxTokens: size("products", "customers", "orders", "shipments"),
+			want:      []string{"products", "customers", "orders", "shipments"},
+		},
+		{
+			name:      "no budget",
+			question:  "How many products are there?",
+			maxTokens: 0,
+			want:      []string{"products", "customers", "orders", "shipments"},
+		},
+		{
+			name:      "ranked by shared words",
+			question:  "What is the average price of a product?",
+			maxTokens: size("products"),
+			want:      []string{"products"},
+		},
+		{
+			name:      "synonyms and glossary terms",
+			question:  "Which deliveries went to big spenders?",
+			maxTokens: size("customers", "shipments"),
+			want:      []string{"customers", "shipments"},
+		},
+		{
+			name:      "related tables are boosted",
+			question:  "How many orders were placed last week?",
+			maxTokens: size("customers", "orders"),
+			want:      []string{"customers", "orders"},
+		},
+		{
+			name:      "tables too large are skipped",
+			question:  "When was the last delivery of an order?",
+			maxTokens: size("customers", "orders"),
+			want:      []string{"customers", "orders"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			relevant := s.Relevant(test.question, test.maxTokens, counts)
+			var got []string
+			for _, table := range relevant.Tables {
+				got = append(got, table.Name)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got tables %v, want %v", got, test.want)
+			}
+			if test.maxTokens > 0 {
+				if tokens := countTokens(relevant.String()); tokens > test.maxTokens {
+					t.Errorf("got %v tokens, over the budget of %v", tokens, test.maxTokens)
+				}
+			}
+		})
+	}
+}
+
+func TestRelevantGlossary(t *testing.T) {
+	s := Schema{
+		Tables: []Table{
+			{Name: "customers", Columns: []Column{{Name: "id"}}},
+			{Name: "orders", Columns: []Column{{Name: "id"}}},
+		},
+		Glossary: []GlossaryTerm{
+			{Term: "fiscal year", Definition: "Starts in April"},
+			{Term: "big spender", Definition: "A customer with large orders", References: []string{"customers"}},
+			{Term: "late order", Definition: "An order shipped after a week", References: []string{"orders.id"}},
+		},
+	}
+	countTokens := func(text string) int {
+		return len(text)
+	}
+	relevant := s.Relevant("list the orders", len(s.String())-1, s.CountTokens(countTokens))
+	var terms []string
+	for _, term := range relevant.Glossary {
+		terms = append(terms, term.Term)
+	}
+	if want := []string{"fiscal year", "late order"}; !reflect.DeepEqual(terms, want) {
+		t.Errorf("got glossary terms %v, want %v", terms, want)
+	}
+	if len(relevant.Tables) != 1 || relevant.Tables[0].Name != "orders" {
+		t.Errorf("expected only the orders table, got %+v", relevant.Tables)
+	}
+}
+
+func TestSearchWords(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{text: "order_items", want: []string{"order", "item"}},
+		{text: "orderItems", want: []string{"order", "item"}},
+		{text: "How many categories are there?", want: []string{"category"}},
+		{text: "addresses, boxes and status", want: []string{"address", "box", "status"}},
+	}
+	for _, test := range tests {
+		if got := searchWords(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.text, got, test.want)
+		}
+	}
+}
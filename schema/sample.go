@@ -0,0 +1,256 @@
+package schema
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/marcboeker/go-duckdb"
+)
+
+// loadSampleRows loads up to rows sample rows for each table, querying up
+// to concurrency tables at once. Tables that cannot be sampled within the
+// timeout are logged and left without sample rows, so one slow table does
+// not prevent the rest of the schema from loading.
+func loadSampleRows(ctx context.Context, loader loader, db *sql.DB, tables []Table, rows, concurrency int, timeout time.Duration) {
+	if rows < 1 {
+		return
+	}
+	forEachTable(tables, concurrency, "Sampled", func(table *Table) {
+		sampleRows, err := loadTableSample(ctx, loader.sampleQueries(*table, rows), timeout, db, table.Columns)
+		if err != nil {
+			log.Printf("Skipping sample rows for %v: %v", table.QualifiedName(), err)
+			return
+		}
+		table.SampleRows = sampleRows
+	})
+}
+
+// forEachTable calls fn for each table, for up to concurrency tables at once.
+// Progress is logged using the given verb, such as "Sampled".
+func forEachTable(tables []Table, concurrency int, verb string, fn func(table *Table)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	progressInterval := len(tables) / 10
+	if progressInterval < 1 {
+		progressInterval = 1
+	}
+
+	var processed int64
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range work {
+				fn(&tables[index])
+
+				done := atomic.AddInt64(&processed, 1)
+				if done%int64(progressInterval) == 0 || done == int64(len(tables)) {
+					log.Printf("%v %v/%v tables", verb, done, len(tables))
+				}
+			}
+		}()
+	}
+
+	for index := range tables {
+		work <- index
+	}
+	close(work)
+	wg.Wait()
+}
+
+// loadTableSample runs each query in turn until one returns rows. This
+// allows sampling methods that may return no rows for small tables to fall
+// back to selecting rows directly.
+func loadTableSample(ctx context.Context, queries []string, timeout time.Duration, db *sql.DB, columns []Column) ([][]Value, error) {
+	for _, query := range queries {
+		sampleRows, err := loadSample(ctx, query, timeout, db, columns)
+		if err != nil {
+			return nil, err
+		}
+		if len(sampleRows) > 0 {
+			return sampleRows, nil
+		}
+	}
+	return nil, nil
+}
+
+// loadSample runs a query selecting sample rows and returns their values for
+// the given columns. Values are matched to columns by name, to allow for
+// columns that were removed by a filter after the rows were selected.
+func loadSample(ctx context.Context, query string, timeout time.Duration, db *sql.DB, columns []Column) ([][]Value, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("loading sample rows: %w", err)
+	}
+	defer rows.Close()
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, fmt.Errorf("loading sample rows: %w", err)
+	}
+
+	positions := make(map[string]int)
+	for i, columnType := range columnTypes {
+		positions[strings.ToLower(columnType.Name())] = i
+	}
+
+	var out [][]Value
+	for rows.Next() {
+		values := make([]interface{}, len(columnTypes))
+		pointers := make([]interface{}, len(columnTypes))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("scanning sample rows: %w", err)
+		}
+
+		var row []Value
+		if len(columns) == len(columnTypes) {
+			for i, value := range values {
+				row = append(row, newValue(value, columnTypes[i].DatabaseTypeName()))
+			}
+		} else {
+			for _, column := range columns {
+				i, ok := positions[strings.ToLower(column.Name)]
+				if !ok {
+					row = append(row, Value{Null: true})
+					continue
+				}
+				row = append(row, newValue(values[i], columnTypes[i].DatabaseTypeName()))
+			}
+		}
+		out = append(out, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("loading sample rows: %w", err)
+	}
+	return out, nil
+}
+
+// Value is a sample value from a table
+type Value struct {
+	// Text is the value formatted as text
+	Text string `json:"text,omitempty"`
+	// Null is true if the value is NULL
+	Null bool `json:"null,omitempty"`
+	// Unquoted is true for numbers and booleans, which are written in SQL
+	// without quotes
+	Unquoted bool `json:"unquoted,omitempty"`
+}
+
+// Literal returns the value as a SQL literal
+func (v Value) Literal() string {
+	switch {
+	case v.Null:
+		return "NULL"
+	case v.Unquoted:
+		return v.Text
+	default:
+		return "'" + strings.ReplaceAll(v.Text, "'", "''") + "'"
+	}
+}
+
+// newValue converts a value scanned from a column of the given database
+// type into a Value
+func newValue(value interface{}, databaseType string) Value {
+	switch v := value.(type) {
+	case nil:
+		return Value{Null: true}
+	case bool:
+		return Value{Text: strings.ToUpper(strconv.FormatBool(v)), Unquoted: true}
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return Value{Text: fmt.Sprint(v), Unquoted: true}
+	case float32:
+		return Value{Text: strconv.FormatFloat(float64(v), 'g', -1, 32), Unquoted: true}
+	case float64:
+		return Value{Text: strconv.FormatFloat(v, 'g', -1, 64), Unquoted: true}
+	case time.Time:
+		if strings.EqualFold(databaseType, "DATE") {
+			return Value{Text: v.Format("2006-01-02")}
+		}
+		return Value{Text: v.Format("2006-01-02 15:04:05.999999999Z07:00")}
+	case duckdb.Decimal:
+		return Value{Text: duckdbDecimalText(v), Unquoted: true}
+	case []byte:
+		return newTextValue(string(v), databaseType)
+	case string:
+		return newTextValue(v, databaseType)
+	default:
+		return Value{Text: fmt.Sprint(v)}
+	}
+}
+
+// newTextValue returns a Value for text returned by the driver. Some drivers
+// return numbers as text, so text from numeric columns is left unquoted
+// where it is a valid number.
+func newTextValue(text, databaseType string) Value {
+	if isNumericType(databaseType) {
+		if _, err := strconv.ParseFloat(text, 64); err == nil {
+			return Value{Text: text, Unquoted: true}
+		}
+	}
+	return Value{Text: text}
+}
+
+// numericTypes are the names of numeric types across the supported
+// databases, as returned by their drivers and information schemas
+var numericTypes = map[string]bool{
+	"INT": true, "INTEGER": true, "TINYINT": true, "SMALLINT": true,
+	"MEDIUMINT": true, "BIGINT": true, "HUGEINT": true, "BYTEINT": true,
+	"UTINYINT": true, "USMALLINT": true, "UINTEGER": true, "UBIGINT": true,
+	"UHUGEINT": true, "INT1": true, "INT2": true, "INT4": true, "INT8": true,
+	"SERIAL": true, "SMALLSERIAL": true, "BIGSERIAL": true,
+	"DEC": true, "DECIMAL": true, "NUMERIC": true, "NUMBER": true,
+	"FIXED": true, "FLOAT": true, "FLOAT4": true, "FLOAT8": true,
+	"DOUBLE": true, "DOUBLE PRECISION": true, "REAL": true,
+}
+
+// isNumericType returns true if a database type name refers to a numeric
+// type. Arrays of numbers are not numeric.
+func isNumericType(databaseType string) bool {
+	return numericTypes[baseType(databaseType)]
+}
+
+// baseType returns the name of a database type in upper case, without its
+// precision or any UNSIGNED or ZEROFILL attributes, as in DECIMAL for
+// decimal(10,2). Arrays keep their brackets, as in INTEGER[].
+func baseType(databaseType string) string {
+	databaseType = strings.ToUpper(strings.TrimSpace(databaseType))
+	if open := strings.IndexByte(databaseType, '('); open >= 0 {
+		if end := strings.IndexByte(databaseType[open:], ')'); end >= 0 {
+			databaseType = databaseType[:open] + " " + databaseType[open+end+1:]
+		}
+	}
+	var words []string
+	for _, word := range strings.Fields(databaseType) {
+		switch word {
+		case "UNSIGNED", "SIGNED", "ZEROFILL":
+		default:
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// limitQuery returns a query selecting up to rows rows from a table
+func limitQuery(table string, rows int) string {
+	return fmt.Sprintf("SELECT * FROM %v LIMIT %d;", table, rows)
+}
+
+// quoteIdentifier quotes an identifier using ANSI SQL double quotes.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
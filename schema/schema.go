@@ -1,83 +1,621 @@
 package schema
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"regexp"
 	"strings"
+	"time"
 )
 
-func Load(dbType string, db *sql.DB) (Schema, error) {
+// Option configures how a schema is loaded
+type Option func(*options)
+
+type options struct {
+	schemas         []string
+	viewDefinitions bool
+	concurrency     int
+	sampleTimeout   time.Duration
+	sampleRows      int
+	profileValues   int
+	cacheDir        string
+	cacheKey        string
+	cacheMaxAge     time.Duration
+	includeTables   []string
+	excludeTables   []string
+	includeColumns  []string
+	excludeColumns  []string
+	redactionRules  []RedactionRule
+	detectedPolicy  RedactionPolicy
+	overlayPath     string
+}
+
+// WithSchemas restricts the tables loaded to those in the named schemas.
+// This is supported for Postgres and Snowflake. For Snowflake, schemas may
+// be qualified by their database, as in sales.public.
+func WithSchemas(schemas ...string) Option {
+	return func(o *options) {
+		o.schemas = schemas
+	}
+}
+
+// WithViewDefinitions includes the query defining each view in the schema.
+func WithViewDefinitions() Option {
+	return func(o *options) {
+		o.viewDefinitions = true
+	}
+}
+
+// WithConcurrency sets the maximum number of tables sampled at once.
+// Defaults to 8.
+func WithConcurrency(concurrency int) Option {
+	return func(o *options) {
+		o.concurrency = concurrency
+	}
+}
+
+// WithSampleTimeout sets how long to wait for sample data from each table
+// before skipping it. Defaults to 30 seconds.
+func WithSampleTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.sampleTimeout = timeout
+	}
+}
+
+// WithSampleRows sets the number of sample rows loaded from each table.
+// Defaults to 1. If zero, no sample rows are loaded.
+func WithSampleRows(rows int) Option {
+	return func(o *options) {
+		o.sampleRows = rows
+	}
+}
+
+// WithValueProfiles profiles the values of each column, listing the
+// distinct values of text columns with at most maxValues distinct values,
+// and the range of values in numeric and date columns. Where available,
+// statistics maintained by the database are used rather than querying each
+// table.
+func WithValueProfiles(maxValues int) Option {
+	return func(o *options) {
+		o.profileValues = maxValues
+	}
+}
+
+// WithCache caches the loaded schema as a JSON file in dir. The key should
+// uniquely identify the database, such as its DSN. A cached schema is only
+// used if the database's fingerprint is unchanged since it was cached, and
+// it is younger than the maximum age set by WithCacheMaxAge.
+func WithCache(dir, key string) Option {
+	return func(o *options) {
+		o.cacheDir = dir
+		o.cacheKey = key
+	}
+}
+
+// WithCacheMaxAge sets how long a cached schema is used for. The structure
+// of the database is checked before using the cache, but table sizes, sample
+// rows and profiles can change without it changing, so are only as fresh as
+// the cache. Defaults to 1 hour.
+func WithCacheMaxAge(maxAge time.Duration) Option {
+	return func(o *options) {
+		o.cacheMaxAge = maxAge
+	}
+}
+
+// WithTableFilter restricts the tables loaded using include and exclude
+// patterns. Tables are loaded if they match any include pattern, or no
+// include patterns are given, and do not match any exclude pattern.
+//
+// Patterns are globs matched case-insensitively against the trailing parts
+// of each table's qualified name, so "tmp_*" matches tables in any schema
+// and "staging.*" matches all tables in the staging schema. Patterns wrapped
+// in slashes, such as "/^audit_\d+$/", are regular expressions matched
+// against the full qualified name.
+func WithTableFilter(include, exclude []string) Option {
+	return func(o *options) {
+		o.includeTables = include
+		o.excludeTables = exclude
+	}
+}
+
+// WithColumnFilter restricts the columns loaded using include and exclude
+// patterns, matched against the qualified name of the table followed by the
+// column name. For example, "password" matches columns named password in any
+// table, and "users.email" matches the email column of the users table.
+// Patterns are interpreted as for WithTableFilter.
+func WithColumnFilter(include, exclude []string) Option {
+	return func(o *options) {
+		o.includeColumns = include
+		o.excludeColumns = exclude
+	}
+}
+
+// WithRedactionRules sets how sample values are redacted for columns matching
+// each rule. The first matching rule is applied.
+func WithRedactionRules(rules ...RedactionRule) Option {
+	return func(o *options) {
+		o.redactionRules = rules
+	}
+}
+
+// WithDetectedDataPolicy sets how sample values that appear to contain
+// personal data, such as email addresses, phone numbers or card numbers,
+// are redacted where no redaction rule matches their column. Defaults to
+// RedactSynthesize.
+func WithDetectedDataPolicy(policy RedactionPolicy) Option {
+	return func(o *options) {
+		o.detectedPolicy = policy
+	}
+}
+
+// newOptions returns the defaults with the given options applied
+func newOptions(opts []Option) options {
+	o := options{
+		concurrency:    8,
+		sampleTimeout:  30 * time.Second,
+		sampleRows:     1,
+		cacheMaxAge:    time.Hour,
+		detectedPolicy: RedactSynthesize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// filters returns the table and column filters and the redactor configured
+// by the options
+func (o options) filters() (tableFilter, columnFilter nameFilter, r redactor, err error) {
+	tableFilter, err = newNameFilter(o.includeTables, o.excludeTables)
+	if err != nil {
+		return nameFilter{}, nameFilter{}, redactor{}, fmt.Errorf("table filter: %w", err)
+	}
+	columnFilter, err = newNameFilter(o.includeColumns, o.excludeColumns)
+	if err != nil {
+		return nameFilter{}, nameFilter{}, redactor{}, fmt.Errorf("column filter: %w", err)
+	}
+	r, err = newRedactor(o.redactionRules, o.detectedPolicy)
+	if err != nil {
+		return nameFilter{}, nameFilter{}, redactor{}, fmt.Errorf("redaction rules: %w", err)
+	}
+	return tableFilter, columnFilter, r, nil
+}
+
+func Load(dbType string, db *sql.DB, opts ...Option) (Schema, error) {
+	o := newOptions(opts)
+	schema, err := load(dbType, db, o)
+	if err != nil {
+		return Schema{}, err
+	}
+	// The overlay is applied after caching, so changes to it take effect
+	// without the cache being invalidated
+	return o.applyOverlay(schema)
+}
+
+func load(dbType string, db *sql.DB, o options) (Schema, error) {
+	tableFilter, columnFilter, redactor, err := o.filters()
+	if err != nil {
+		return Schema{}, err
+	}
 
 	var loader loader
 	switch dbType {
 	case "postgres":
-		loader = &postgresLoader{}
+		loader = &postgresLoader{schemas: o.schemas}
 	case "snowflake":
-		loader = &snowflakeLoader{}
+		loader = &snowflakeLoader{schemas: o.schemas, tables: tableFilter}
+	case "mysql":
+		loader = &mysqlLoader{}
+	case "sqlite3":
+		loader = &sqliteLoader{}
+	case "duckdb":
+		loader = &duckdbLoader{}
 	default:
 		return Schema{}, fmt.Errorf("unsupported database type %v", dbType)
 	}
 
-	var tables []Table
-	tableNames, err := loader.tableList(db)
+	var cachePath, fingerprint string
+	if o.cacheDir != "" {
+		fingerprint, err = loader.fingerprint(db)
+		if err != nil {
+			log.Printf("Not using schema cache, could not fingerprint database: %v", err)
+		} else {
+			cachePath = o.cachePath(dbType)
+			if schema, ok := readCache(cachePath, fingerprint, o.cacheMaxAge); ok {
+				log.Printf("Loaded schema from cache: %v", cachePath)
+				return schema, nil
+			}
+		}
+	}
+
+	start := time.Now()
+	tables, err := loader.loadTables(db)
 	if err != nil {
 		return Schema{}, fmt.Errorf("getting tables: %w", err)
 	}
 
-	log.Printf("Got %v tables", len(tableNames))
+	tables = filterTables(tables, tableFilter, columnFilter)
 
-	for _, table := range tableNames {
-		tableDetail, err := loader.describeTable(table, db)
-		if err != nil {
-			return Schema{}, fmt.Errorf("describing table %v: %w", table, err)
+	log.Printf("Got %v tables in %v", len(tables), time.Since(start))
+
+	if !o.viewDefinitions {
+		for i := range tables {
+			tables[i].Definition = ""
+		}
+	}
+
+	loadSampleRows(context.Background(), loader, db, tables, o.sampleRows, o.concurrency, o.sampleTimeout)
+	// Inspecting tables reads their data, so is skipped if no sample data
+	// should be included
+	if inspector, ok := loader.(tableInspector); ok && o.sampleRows > 0 {
+		forEachTable(tables, o.concurrency, "Inspected", func(table *Table) {
+			ctx, cancel := context.WithTimeout(context.Background(), o.sampleTimeout)
+			defer cancel()
+			if err := inspector.inspectTable(ctx, db, table); err != nil {
+				log.Printf("Skipping inspection of %v: %v", table.QualifiedName(), err)
+			}
+		})
+	}
+	if o.profileValues > 0 {
+		loadProfiles(context.Background(), loader, db, tables, o.profileValues, o.concurrency, o.sampleTimeout)
+	}
+	if redacted := redactor.redactTables(tables); redacted > 0 {
+		log.Printf("Redacted %v sample values", redacted)
+	}
+
+	log.Printf("Loaded schema in %v", time.Since(start))
+
+	schema := Schema{
+		Dialect: dialectNames[dbType],
+		Tables:  tables,
+	}
+	if cachePath != "" {
+		if err := writeCache(cachePath, fingerprint, schema); err != nil {
+			log.Printf("Could not cache schema: %v", err)
 		}
-		tables = append(tables, tableDetail)
 	}
-	return Schema{
-		Tables: tables,
-	}, nil
+	return schema, nil
+}
+
+// dialectNames maps database types to the name of the SQL dialect they use
+var dialectNames = map[string]string{
+	"postgres":  "PostgreSQL",
+	"snowflake": "Snowflake",
+	"mysql":     "MySQL",
+	"sqlite3":   "SQLite",
+	"duckdb":    "DuckDB",
 }
 
 type loader interface {
-	tableList(db *sql.DB) ([]string, error)
-	describeTable(table string, db *sql.DB) (Table, error)
+	// loadTables returns the tables in the database with their columns,
+	// keys and comments, using as few catalog queries as possible.
+	// Sample rows are loaded separately.
+	loadTables(db *sql.DB) ([]Table, error)
+	// sampleQueries returns queries selecting up to rows sample rows from a
+	// table. Each query is tried in turn until one returns rows.
+	sampleQueries(table Table, rows int) []string
+	// quotedName returns the name of a table as used in queries
+	quotedName(table Table) string
+	// quote quotes an identifier, such as a column name, for use in queries
+	quote(identifier string) string
+	// fingerprint returns a value that changes when the structure of the
+	// database changes, and is cheap to compute relative to loadTables.
+	fingerprint(db *sql.DB) (string, error)
+}
+
+// tableInspector is implemented by loaders that load further details of
+// columns by querying the data in a table, such as the keys used in JSON
+// columns.
+type tableInspector interface {
+	inspectTable(ctx context.Context, db *sql.DB, table *Table) error
+}
+
+// tableIndex maps qualified table names to their position in a list of
+// tables, so catalog queries covering many tables can be matched up with
+// the tables they describe.
+type tableIndex map[string]int
+
+func indexTables(tables []Table) tableIndex {
+	index := make(tableIndex)
+	for i, table := range tables {
+		index[table.QualifiedName()] = i
+	}
+	return index
+}
+
+// find returns the table with the same qualified name as the given table,
+// or nil if it was not loaded.
+func (t tableIndex) find(tables []Table, table Table) *Table {
+	i, ok := t[table.QualifiedName()]
+	if !ok {
+		return nil
+	}
+	return &tables[i]
 }
 
 // Schema represents a simplified database schema, containing a list of tables
 type Schema struct {
-	Tables []Table
+	// Dialect is the name of the SQL dialect queries should be written in
+	Dialect string  `json:"dialect"`
+	Tables  []Table `json:"tables"`
+	// Glossary defines business terms used in questions, from an Overlay
+	Glossary []GlossaryTerm `json:"glossary,omitempty"`
 }
 
-// String returns the SQL query to create all tables in the schema
+// glossaryHeader introduces the glossary when the schema is written as a
+// string
+const glossaryHeader = "-- Glossary of business terms:"
+
+// String returns the SQL query to create all tables in the schema, followed
+// by any glossary as SQL comments
 func (s Schema) String() string {
 	var tables []string
 	for _, table := range s.Tables {
 		tables = append(tables, table.String())
 	}
+	if len(s.Glossary) > 0 {
+		var glossary strings.Builder
+		glossary.WriteString(glossaryHeader)
+		for _, term := range s.Glossary {
+			glossary.WriteString("\n-- " + term.String())
+		}
+		tables = append(tables, glossary.String())
+	}
 	return strings.Join(tables, "\n\n")
 }
 
 // Table represents a table in a database
 type Table struct {
-	Name      string
-	Columns   []Column
-	SampleRow []string
+	// Database and Schema identify the namespace containing the table.
+	// They are empty where tables are loaded from a single namespace.
+	Database string `json:"database,omitempty"`
+	Schema   string `json:"schema,omitempty"`
+	Name     string `json:"name"`
+	// Kind is the kind of relation, such as a base table or a view
+	Kind TableKind `json:"kind,omitempty"`
+	// Definition is the query defining a view, if known
+	Definition  string       `json:"definition,omitempty"`
+	Comment     string       `json:"comment,omitempty"`
+	Columns     []Column     `json:"columns"`
+	PrimaryKey  []string     `json:"primary_key,omitempty"`
+	ForeignKeys []ForeignKey `json:"foreign_keys,omitempty"`
+	SampleRows  [][]Value    `json:"sample_rows,omitempty"`
+	// Stats describes the size and freshness of the table, where known
+	Stats *TableStats `json:"stats,omitempty"`
+	// Synonyms lists other names the table is known by
+	Synonyms []string `json:"synonyms,omitempty"`
 }
 
 // String returns the SQL query to create a table with its columns.
+// Comments on the table and its columns are included as SQL comments.
 func (t Table) String() string {
-	var columns []string
+	var definitions, comments []string
 	for _, column := range t.Columns {
-		columns = append(columns, fmt.Sprintf("%s %s", column.Name, column.Type))
+		definitions = append(definitions, column.String())
+		comments = append(comments, column.annotation())
+	}
+	if len(t.PrimaryKey) > 0 {
+		definitions = append(definitions, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(t.PrimaryKey, ", ")))
+		comments = append(comments, "")
+	}
+	for _, foreignKey := range t.ForeignKeys {
+		definitions = append(definitions, foreignKey.String())
+		comments = append(comments, "")
+	}
+
+	var out strings.Builder
+	if t.Comment != "" {
+		fmt.Fprintf(&out, "-- %s\n", singleLine(t.Comment))
+	}
+	if len(t.Synonyms) > 0 {
+		fmt.Fprintf(&out, "-- also called: %s\n", strings.Join(t.Synonyms, ", "))
+	}
+	if t.Stats != nil {
+		if stats := t.Stats.String(); stats != "" {
+			fmt.Fprintf(&out, "-- %s\n", stats)
+		}
+	}
+	kind := t.Kind
+	if kind == "" {
+		kind = BaseTable
+	}
+	fmt.Fprintf(&out, "CREATE %s %s", kind, t.QualifiedName())
+	// Views loaded from DDL may not list their columns
+	if len(definitions) > 0 {
+		out.WriteString(" (\n")
+		for i, definition := range definitions {
+			out.WriteString("  " + definition)
+			if i < len(definitions)-1 {
+				out.WriteString(",")
+			}
+			if comments[i] != "" {
+				out.WriteString(" -- " + singleLine(comments[i]))
+			}
+			out.WriteString("\n")
+		}
+		out.WriteString(")")
+	}
+	if t.Definition != "" {
+		fmt.Fprintf(&out, " AS\n%s", strings.TrimSuffix(strings.TrimSpace(t.Definition), ";"))
+	}
+	out.WriteString(";")
+
+	if len(t.SampleRows) > 0 {
+		fmt.Fprintf(&out, "\nINSERT INTO %s VALUES", t.QualifiedName())
+		for i, row := range t.SampleRows {
+			var literals []string
+			for _, value := range row {
+				literals = append(literals, value.Literal())
+			}
+			if len(t.SampleRows) == 1 {
+				fmt.Fprintf(&out, " (%s)", strings.Join(literals, ", "))
+				break
+			}
+			fmt.Fprintf(&out, "\n  (%s)", strings.Join(literals, ", "))
+			if i < len(t.SampleRows)-1 {
+				out.WriteString(",")
+			}
+		}
+		out.WriteString(";")
+	}
+	return out.String()
+}
+
+// viewQueryPattern matches the start of a CREATE VIEW statement, up to the
+// query defining the view
+var viewQueryPattern = regexp.MustCompile(`(?is)^\s*CREATE\b.*?\bAS\s+`)
+
+// viewQuery returns the query from a CREATE VIEW statement, for databases
+// that only store the full statement.
+func viewQuery(createStatement string) string {
+	return viewQueryPattern.ReplaceAllString(createStatement, "")
+}
+
+// TableKind is the kind of relation represented by a Table
+type TableKind string
+
+const (
+	BaseTable        TableKind = "TABLE"
+	View             TableKind = "VIEW"
+	MaterializedView TableKind = "MATERIALIZED VIEW"
+)
+
+// IsView returns true if the table is a view or materialized view
+func (t Table) IsView() bool {
+	return t.Kind == View || t.Kind == MaterializedView
+}
+
+// QualifiedName returns the name of the table, qualified by its database
+// and schema where known.
+func (t Table) QualifiedName() string {
+	var parts []string
+	for _, part := range []string{t.Database, t.Schema, t.Name} {
+		if part != "" {
+			parts = append(parts, part)
+		}
 	}
+	return strings.Join(parts, ".")
+}
 
-	return fmt.Sprintf(`CREATE TABLE %s (%s)
-INSERT INTO %s VALUES (%s);`, t.Name, strings.Join(columns, ", "), t.Name, strings.Join(t.SampleRow, ", "))
+// singleLine collapses whitespace, including newlines, so that the given text
+// may be used in a single line SQL comment.
+func singleLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
 }
 
 // Column represents a column in a table
 type Column struct {
-	Name string
-	Type string
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	NotNull bool   `json:"not_null,omitempty"`
+	Default string `json:"default,omitempty"`
+	Comment string `json:"comment,omitempty"`
+	// EnumValues lists the values of enum types, or arrays of enums
+	EnumValues []string `json:"enum_values,omitempty"`
+	// ElementType is the type of the elements of array types
+	ElementType string `json:"element_type,omitempty"`
+	// Fields lists the fields of composite types, with their types
+	Fields []string `json:"fields,omitempty"`
+	// JSONKeys lists commonly seen top-level keys of objects in JSON columns
+	JSONKeys []string `json:"json_keys,omitempty"`
+	// NestedFields describes the structure of semi-structured columns
+	NestedFields []NestedField `json:"nested_fields,omitempty"`
+	// Profile summarizes the values in the column, if it has been profiled
+	Profile *ColumnProfile `json:"profile,omitempty"`
+	// Synonyms lists other names the column is known by
+	Synonyms []string `json:"synonyms,omitempty"`
+}
+
+// annotation returns the text of the SQL comment describing the column,
+// combining its comment with any profile of its values.
+func (c Column) annotation() string {
+	var parts []string
+	if c.Comment != "" {
+		parts = append(parts, c.Comment)
+	}
+	if len(c.Synonyms) > 0 {
+		parts = append(parts, "also called: "+strings.Join(c.Synonyms, ", "))
+	}
+	if c.ElementType != "" && !strings.HasSuffix(c.Type, "[]") {
+		parts = append(parts, "array of "+c.ElementType)
+	}
+	if len(c.EnumValues) > 0 {
+		var literals []string
+		for _, value := range c.EnumValues {
+			literals = append(literals, Value{Text: value}.Literal())
+		}
+		parts = append(parts, "enum: "+strings.Join(literals, ", "))
+	}
+	if len(c.Fields) > 0 {
+		parts = append(parts, "fields: "+strings.Join(c.Fields, ", "))
+	}
+	if len(c.JSONKeys) > 0 {
+		parts = append(parts, "keys: "+strings.Join(c.JSONKeys, ", "))
+	}
+	if len(c.NestedFields) > 0 {
+		var fields []string
+		for _, field := range c.NestedFields {
+			fields = append(fields, field.String())
+		}
+		parts = append(parts, fmt.Sprintf(
+			"structure: %v; query as %v:path::type, using LATERAL FLATTEN for [] arrays",
+			strings.Join(fields, ", "), c.Name,
+		))
+	}
+	if c.Profile != nil {
+		if profile := c.Profile.String(); profile != "" {
+			parts = append(parts, profile)
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// String returns the definition of the column as used in a CREATE TABLE
+// statement.
+func (c Column) String() string {
+	definition := c.Name
+	if c.Type != "" {
+		definition += " " + c.Type
+	}
+	if c.NotNull {
+		definition += " NOT NULL"
+	}
+	if c.Default != "" {
+		definition += " DEFAULT " + c.Default
+	}
+	return definition
+}
+
+// NestedField is a path within a semi-structured column, such as a Snowflake
+// VARIANT, with the type of value usually found there
+type NestedField struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+}
+
+func (f NestedField) String() string {
+	return f.Path + " " + f.Type
+}
+
+// ForeignKey represents a reference from columns in one table to columns in
+// another.
+type ForeignKey struct {
+	Columns           []string `json:"columns"`
+	ReferencedTable   string   `json:"referenced_table"`
+	ReferencedColumns []string `json:"referenced_columns,omitempty"`
+}
+
+// String returns the foreign key as a table constraint clause.
+func (f ForeignKey) String() string {
+	if len(f.ReferencedColumns) == 0 {
+		return fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s", strings.Join(f.Columns, ", "), f.ReferencedTable)
+	}
+	return fmt.Sprintf(
+		"FOREIGN KEY (%s) REFERENCES %s(%s)",
+		strings.Join(f.Columns, ", "),
+		f.ReferencedTable,
+		strings.Join(f.ReferencedColumns, ", "),
+	)
 }
@@ -1,115 +1,404 @@
 package schema
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
-	"os"
-	"time"
+	"sort"
+	"strconv"
+	"strings"
 )
 
-type snowflakeLoader struct{}
+type snowflakeLoader struct {
+	// schemas restricts the tables listed to those in the named schemas,
+	// each optionally qualified by its database. If empty, tables are
+	// listed from the current database, or the whole account if there is
+	// none.
+	schemas []string
+	// tables selects the tables for which details are loaded, so that
+	// columns and keys are not loaded for tables that would be filtered out
+	tables nameFilter
+}
 
-func (s *snowflakeLoader) tableList(db *sql.DB) ([]string, error) {
-	var tables []string
-	rows, err := db.Query("SHOW TERSE TABLES;")
+func (s *snowflakeLoader) loadTables(db *sql.DB) ([]Table, error) {
+	tables, err := s.tableList(db)
 	if err != nil {
-		return nil, fmt.Errorf("listing tables: %w", err)
+		return nil, err
 	}
-	defer rows.Close()
+	index := indexTables(tables)
 
-	for rows.Next() {
-		var created, name, kind, db_name, schema_name string
-		rows.Scan(&created, &name, &kind, &db_name, &schema_name)
-		if os.Getenv("SNOWFLAKE_DATABASE") == "" || db_name != os.Getenv("SNOWFLAKE_DATABASE") {
-			continue
+	// Catalog details are loaded in bulk from each database's
+	// information_schema, for the schemas containing the selected tables
+	for _, database := range s.databases(tables) {
+		if err := s.loadDetails(db, database, tables, index); err != nil {
+			return nil, fmt.Errorf("loading details for database %v: %w", database, err)
 		}
-		if os.Getenv("SNOWFLAKE_SCHEMA") == "" || schema_name != os.Getenv("SNOWFLAKE_SCHEMA") {
-			continue
+		if err := s.loadColumns(db, database, tables, index); err != nil {
+			return nil, fmt.Errorf("loading columns for database %v: %w", database, err)
+		}
+		if err := s.loadKeys(db, database, tables, index); err != nil {
+			return nil, fmt.Errorf("loading keys for database %v: %w", database, err)
+		}
+	}
+	return tables, nil
+}
+
+// databases returns the distinct databases containing the given tables
+func (s *snowflakeLoader) databases(tables []Table) []string {
+	var databases []string
+	seen := make(map[string]bool)
+	for _, table := range tables {
+		if !seen[table.Database] {
+			seen[table.Database] = true
+			databases = append(databases, table.Database)
+		}
+	}
+	return databases
+}
+
+// tableList lists the selected tables and views
+func (s *snowflakeLoader) tableList(db *sql.DB) ([]Table, error) {
+	var commands []string
+	for _, kind := range []string{"TABLES", "VIEWS"} {
+		if len(s.schemas) == 0 {
+			commands = append(commands, fmt.Sprintf("SHOW TERSE %v;", kind))
+		}
+		for _, schemaName := range s.schemas {
+			commands = append(commands, fmt.Sprintf("SHOW TERSE %v IN SCHEMA %v;", kind, snowflakeObjectName(schemaName)))
+		}
+	}
+
+	var tables []Table
+	for _, command := range commands {
+		rows, err := s.show(command, db)
+		if err != nil {
+			return nil, fmt.Errorf("listing tables: %w", err)
+		}
+
+		for _, row := range rows {
+			kind := BaseTable
+			switch row["kind"] {
+			case "VIEW":
+				kind = View
+			case "MATERIALIZED_VIEW":
+				kind = MaterializedView
+			}
+
+			table := Table{
+				Database: row["database_name"],
+				Schema:   row["schema_name"],
+				Name:     row["name"],
+				Kind:     kind,
+			}
+			if s.tables.selects(table.QualifiedName()) {
+				tables = append(tables, table)
+			}
 		}
-		tables = append(tables, fmt.Sprintf("%v.%v.%v", db_name, schema_name, name))
 	}
 	return tables, nil
 }
 
-// describeTable returns a list of Column descriptions for a given table
-func (s *snowflakeLoader) describeTable(table string, db *sql.DB) (Table, error) {
-	var columns []Column
-	rows, err := db.Query(fmt.Sprintf("DESCRIBE TABLE %v", table))
+// snowflakeObjectName returns a dot-separated name for use in a command,
+// quoting only the parts that need it, so that unquoted names are resolved
+// case-insensitively as they would be in a query
+func snowflakeObjectName(name string) string {
+	var parts []string
+	for _, part := range strings.Split(name, ".") {
+		if !plainIdentifier.MatchString(part) {
+			part = quoteIdentifier(part)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// schemaCondition returns a condition restricting an information_schema
+// query to the schemas of a database that contain the given tables, where
+// column is the name of the schema column, with its arguments
+func (s *snowflakeLoader) schemaCondition(column, database string, tables []Table) (string, []interface{}) {
+	var placeholders []string
+	var args []interface{}
+	seen := make(map[string]bool)
+	for _, table := range tables {
+		if table.Database == database && !seen[table.Schema] {
+			seen[table.Schema] = true
+			placeholders = append(placeholders, "?")
+			args = append(args, table.Schema)
+		}
+	}
+	return fmt.Sprintf("%[1]v != 'INFORMATION_SCHEMA' AND %[1]v IN (%[2]v)", column, strings.Join(placeholders, ", ")), args
+}
+
+// loadDetails loads comments and stats for all tables and views in a
+// database, and the queries defining each view
+func (s *snowflakeLoader) loadDetails(db *sql.DB, database string, tables []Table, index tableIndex) error {
+	condition, args := s.schemaCondition("t.table_schema", database, tables)
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT
+			t.table_schema,
+			t.table_name,
+			COALESCE(t.comment, ''),
+			COALESCE(v.view_definition, ''),
+			t.row_count,
+			t.bytes,
+			t.last_altered
+		FROM %[1]v.information_schema.tables t
+		LEFT JOIN %[1]v.information_schema.views v ON v.table_schema = t.table_schema AND v.table_name = t.table_name
+		WHERE %[2]v`,
+		quoteIdentifier(database), condition,
+	), args...)
 	if err != nil {
-		return Table{}, fmt.Errorf("describing tables: %w", err)
+		return err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var describeColumns []interface{}
-		for i := 0; i < 11; i++ {
-			var describeColumn string
-			describeColumns = append(describeColumns, &describeColumn)
+		var schemaName, tableName, comment, createStatement string
+		var rowCount, sizeBytes sql.NullInt64
+		var lastAltered sql.NullTime
+		err := rows.Scan(&schemaName, &tableName, &comment, &createStatement, &rowCount, &sizeBytes, &lastAltered)
+		if err != nil {
+			return err
+		}
+		if table := index.find(tables, Table{Database: database, Schema: schemaName, Name: tableName}); table != nil {
+			table.Comment = comment
+			table.Stats = newTableStats(rowCount, sizeBytes, lastAltered)
+			if createStatement != "" {
+				table.Definition = viewQuery(createStatement)
+			}
 		}
+	}
+	return rows.Err()
+}
 
+// loadColumns loads the columns of all tables and views in a database
+func (s *snowflakeLoader) loadColumns(db *sql.DB, database string, tables []Table, index tableIndex) error {
+	condition, args := s.schemaCondition("table_schema", database, tables)
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT table_schema, table_name, column_name, data_type, is_nullable = 'NO', COALESCE(column_default, ''), COALESCE(comment, '')
+		FROM %v.information_schema.columns
+		WHERE %v
+		ORDER BY table_schema, table_name, ordinal_position`,
+		quoteIdentifier(database), condition,
+	), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var schemaName, tableName string
 		var column Column
-		rows.Scan(describeColumns...)
-		cN := describeColumns[0].(*string)
-		cT := describeColumns[1].(*string)
-		column.Name = fmt.Sprint(*cN)
-		column.Type = fmt.Sprint(*cT)
-		columns = append(columns, column)
+		err := rows.Scan(&schemaName, &tableName, &column.Name, &column.Type, &column.NotNull, &column.Default, &column.Comment)
+		if err != nil {
+			return err
+		}
+		if table := index.find(tables, Table{Database: database, Schema: schemaName, Name: tableName}); table != nil {
+			table.Columns = append(table.Columns, column)
+		}
 	}
+	return rows.Err()
+}
 
-	exampleRow, err := db.Query(fmt.Sprintf("SELECT * FROM %v LIMIT 1;", table))
+// loadKeys loads the primary key columns and foreign keys for all tables in
+// a database
+func (s *snowflakeLoader) loadKeys(db *sql.DB, database string, tables []Table, index tableIndex) error {
+	primaryKeyRows, err := s.show(fmt.Sprintf("SHOW PRIMARY KEYS IN DATABASE %v", quoteIdentifier(database)), db)
 	if err != nil {
-		return Table{}, fmt.Errorf("loading example row: %w", err)
+		return fmt.Errorf("loading primary keys: %w", err)
 	}
-	defer exampleRow.Close()
-	var values []interface{}
-	// Read exactly one row, assuming there are any
-	for exampleRow.Next() && len(values) == 0 {
-		for i := 0; i < len(columns); i++ {
-			var value interface{}
-			values = append(values, &value)
-		}
-		err = exampleRow.Scan(values...)
-		if err != nil {
-			return Table{}, fmt.Errorf("scanning example row: %w", err)
+	sortByKeySequence(primaryKeyRows)
+
+	for _, row := range primaryKeyRows {
+		table := index.find(tables, Table{
+			Database: row["database_name"],
+			Schema:   row["schema_name"],
+			Name:     row["table_name"],
+		})
+		if table != nil {
+			table.PrimaryKey = append(table.PrimaryKey, row["column_name"])
 		}
 	}
 
-	var stringValues []string
-	for _, rvp := range values {
-		// Based on code from sqltocsv
-		rawValue := *rvp.(*interface{})
-		var value interface{}
-		byteArray, ok := rawValue.([]byte)
-		if ok {
-			value = string(byteArray)
-		} else {
-			value = rawValue
+	importedKeyRows, err := s.show(fmt.Sprintf("SHOW IMPORTED KEYS IN DATABASE %v", quoteIdentifier(database)), db)
+	if err != nil {
+		return fmt.Errorf("loading foreign keys: %w", err)
+	}
+	sortByKeySequence(importedKeyRows)
+
+	foreignKeyIndex := make(map[string]int)
+	for _, row := range importedKeyRows {
+		table := index.find(tables, Table{
+			Database: row["fk_database_name"],
+			Schema:   row["fk_schema_name"],
+			Name:     row["fk_table_name"],
+		})
+		if table == nil {
+			continue
 		}
 
-		float64Value, ok := value.(float64)
-		if ok {
-			value = fmt.Sprintf("%v", float64Value)
-		} else {
-			float32Value, ok := value.(float32)
-			if ok {
-				value = fmt.Sprintf("%v", float32Value)
-			}
+		key := table.QualifiedName() + "." + row["fk_name"]
+		i, ok := foreignKeyIndex[key]
+		if !ok {
+			i = len(table.ForeignKeys)
+			foreignKeyIndex[key] = i
+			table.ForeignKeys = append(table.ForeignKeys, ForeignKey{
+				ReferencedTable: Table{
+					Database: row["pk_database_name"],
+					Schema:   row["pk_schema_name"],
+					Name:     row["pk_table_name"],
+				}.QualifiedName(),
+			})
 		}
+		table.ForeignKeys[i].Columns = append(table.ForeignKeys[i].Columns, row["fk_column_name"])
+		table.ForeignKeys[i].ReferencedColumns = append(table.ForeignKeys[i].ReferencedColumns, row["pk_column_name"])
+	}
+	return nil
+}
+
+// sampleQueries samples a fixed number of rows from tables, and selects the
+// first rows of views
+func (s *snowflakeLoader) sampleQueries(table Table, rows int) []string {
+	if table.IsView() {
+		return []string{limitQuery(s.quotedName(table), rows)}
+	}
+	return []string{fmt.Sprintf("SELECT * FROM %v SAMPLE (%d ROWS);", s.quotedName(table), rows)}
+}
 
-		timeValue, ok := value.(time.Time)
-		if ok {
-			value = timeValue.Format(time.RFC1123)
+func (s *snowflakeLoader) quotedName(table Table) string {
+	return fmt.Sprintf(
+		"%v.%v.%v",
+		quoteIdentifier(table.Database),
+		quoteIdentifier(table.Schema),
+		quoteIdentifier(table.Name),
+	)
+}
+
+func (s *snowflakeLoader) quote(identifier string) string {
+	return quoteIdentifier(identifier)
+}
+
+// show runs a SHOW command and returns each result row as a map of column
+// name to value, as the set of columns returned varies between commands.
+func (s *snowflakeLoader) show(command string, db *sql.DB) ([]map[string]string, error) {
+	rows, err := db.Query(command)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []map[string]string
+	for rows.Next() {
+		values := make([]sql.NullString, len(names))
+		pointers := make([]interface{}, len(names))
+		for i := range values {
+			pointers[i] = &values[i]
 		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]string)
+		for i, name := range names {
+			row[name] = values[i].String
+		}
+		out = append(out, row)
+	}
+	return out, nil
+}
+
+// sortByKeySequence orders the rows returned by a SHOW ... KEYS command by
+// their position within the key.
+func sortByKeySequence(rows []map[string]string) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, _ := strconv.Atoi(rows[i]["key_sequence"])
+		b, _ := strconv.Atoi(rows[j]["key_sequence"])
+		return a < b
+	})
+}
 
-		if value == nil {
-			stringValues = append(stringValues, "")
-		} else {
-			stringValues = append(stringValues, fmt.Sprintf("%v", value))
+// fingerprint hashes the list of tables along with the time of the most
+// recent DDL statement in each database
+func (s *snowflakeLoader) fingerprint(db *sql.DB) (string, error) {
+	tables, err := s.tableList(db)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	for _, table := range tables {
+		fmt.Fprintf(hash, "%v:%v\n", table.QualifiedName(), table.Kind)
+	}
+	for _, database := range s.databases(tables) {
+		var lastDDL sql.NullString
+		condition, args := s.schemaCondition("table_schema", database, tables)
+		err := db.QueryRow(fmt.Sprintf(
+			"SELECT TO_VARCHAR(MAX(last_ddl)) FROM %v.information_schema.tables WHERE %v",
+			quoteIdentifier(database), condition,
+		), args...).Scan(&lastDDL)
+		if err != nil {
+			return "", fmt.Errorf("fingerprinting schema: %w", err)
 		}
+		fmt.Fprintf(hash, "%v:%v\n", database, lastDDL.String)
 	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
 
-	return Table{
-		Name:      table,
-		Columns:   columns,
-		SampleRow: stringValues,
-	}, nil
+// maxNestedFields is the number of paths listed for each semi-structured
+// column
+const maxNestedFields = 50
+
+// inspectTable infers the structure of the semi-structured columns of a
+// table from up to 1000 sampled rows. Each path is recorded with its most
+// common type, with array indices replaced by [] so elements of the same
+// array are described once.
+func (s *snowflakeLoader) inspectTable(ctx context.Context, db *sql.DB, table *Table) error {
+	if table.IsView() {
+		return nil
+	}
+	for i, column := range table.Columns {
+		if column.Type != "VARIANT" && column.Type != "OBJECT" && column.Type != "ARRAY" {
+			continue
+		}
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+			SELECT REGEXP_REPLACE(f.path, '\\[[0-9]+\\]', '[]') AS field_path, TYPEOF(f.value) AS field_type, COUNT(*) AS occurrences
+			FROM (SELECT %[1]v AS value FROM %[2]v SAMPLE (1000 ROWS)) s,
+				LATERAL FLATTEN(input => s.value, recursive => true) f
+			GROUP BY 1, 2
+			ORDER BY 3 DESC, 1
+			LIMIT %[3]d`,
+			quoteIdentifier(column.Name), s.quotedName(*table), maxNestedFields,
+		))
+		if err != nil {
+			return fmt.Errorf("inferring structure of %v: %w", column.Name, err)
+		}
+		var fields []NestedField
+		seen := make(map[string]bool)
+		for rows.Next() {
+			var field NestedField
+			var count int64
+			if err := rows.Scan(&field.Path, &field.Type, &count); err != nil {
+				rows.Close()
+				return fmt.Errorf("scanning structure of %v: %w", column.Name, err)
+			}
+			if seen[field.Path] || field.Type == "NULL_VALUE" {
+				continue
+			}
+			seen[field.Path] = true
+			fields = append(fields, field)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("inferring structure of %v: %w", column.Name, err)
+		}
+		sort.Slice(fields, func(i, j int) bool {
+			return fields[i].Path < fields[j].Path
+		})
+		table.Columns[i].NestedFields = fields
+	}
+	return nil
 }
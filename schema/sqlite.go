@@ -0,0 +1,170 @@
+package schema
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type sqliteLoader struct{}
+
+func (s *sqliteLoader) loadTables(db *sql.DB) ([]Table, error) {
+	tables, err := s.tableList(db)
+	if err != nil {
+		return nil, err
+	}
+	index := indexTables(tables)
+
+	if err := s.loadColumns(db, tables, index); err != nil {
+		return nil, err
+	}
+	if err := s.loadPrimaryKeys(db, tables, index); err != nil {
+		return nil, err
+	}
+	if err := s.loadForeignKeys(db, tables, index); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+func (s *sqliteLoader) tableList(db *sql.DB) ([]Table, error) {
+	var tables []Table
+	rows, err := db.Query("SELECT name, type = 'view', CASE WHEN type = 'view' THEN sql ELSE '' END FROM sqlite_master WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite_%' ORDER BY name;")
+	if err != nil {
+		return nil, fmt.Errorf("listing tables: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table Table
+		var isView bool
+		var createStatement string
+		if err := rows.Scan(&table.Name, &isView, &createStatement); err != nil {
+			return nil, fmt.Errorf("scanning tables: %w", err)
+		}
+		// SQLite does not support comments on tables or columns
+		table.Kind = BaseTable
+		if isView {
+			table.Kind = View
+			table.Definition = viewQuery(createStatement)
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+// loadColumns loads the columns for all tables
+func (s *sqliteLoader) loadColumns(db *sql.DB, tables []Table, index tableIndex) error {
+	rows, err := db.Query(`
+		SELECT m.name, p.name, p.type, p."notnull", COALESCE(p.dflt_value, '')
+		FROM sqlite_master m
+		JOIN pragma_table_info(m.name) p
+		WHERE m.type IN ('table', 'view') AND m.name NOT LIKE 'sqlite_%'
+		ORDER BY m.name, p.cid`,
+	)
+	if err != nil {
+		return fmt.Errorf("describing tables: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tableName string
+		var column Column
+		err := rows.Scan(&tableName, &column.Name, &column.Type, &column.NotNull, &column.Default)
+		if err != nil {
+			return fmt.Errorf("scanning columns: %w", err)
+		}
+		if table := index.find(tables, Table{Name: tableName}); table != nil {
+			table.Columns = append(table.Columns, column)
+		}
+	}
+	return rows.Err()
+}
+
+// loadPrimaryKeys loads the primary key columns for all tables
+func (s *sqliteLoader) loadPrimaryKeys(db *sql.DB, tables []Table, index tableIndex) error {
+	rows, err := db.Query(`
+		SELECT m.name, p.name
+		FROM sqlite_master m
+		JOIN pragma_table_info(m.name) p
+		WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%' AND p.pk > 0
+		ORDER BY m.name, p.pk`,
+	)
+	if err != nil {
+		return fmt.Errorf("loading primary keys: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tableName, column string
+		if err := rows.Scan(&tableName, &column); err != nil {
+			return fmt.Errorf("scanning primary keys: %w", err)
+		}
+		if table := index.find(tables, Table{Name: tableName}); table != nil {
+			table.PrimaryKey = append(table.PrimaryKey, column)
+		}
+	}
+	return rows.Err()
+}
+
+// loadForeignKeys loads the foreign keys for all tables
+func (s *sqliteLoader) loadForeignKeys(db *sql.DB, tables []Table, index tableIndex) error {
+	rows, err := db.Query(`
+		SELECT m.name, f.id, f."table", f."from", f."to"
+		FROM sqlite_master m
+		JOIN pragma_foreign_key_list(m.name) f
+		WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%'
+		ORDER BY m.name, f.id, f.seq`,
+	)
+	if err != nil {
+		return fmt.Errorf("loading foreign keys: %w", err)
+	}
+	defer rows.Close()
+
+	lastTable, lastID := "", -1
+	for rows.Next() {
+		var tableName, referencedTable, column string
+		var id int
+		var referencedColumn sql.NullString
+		if err := rows.Scan(&tableName, &id, &referencedTable, &column, &referencedColumn); err != nil {
+			return fmt.Errorf("scanning foreign keys: %w", err)
+		}
+		table := index.find(tables, Table{Name: tableName})
+		if table == nil {
+			continue
+		}
+		if tableName != lastTable || id != lastID {
+			table.ForeignKeys = append(table.ForeignKeys, ForeignKey{ReferencedTable: referencedTable})
+			lastTable, lastID = tableName, id
+		}
+		foreignKey := &table.ForeignKeys[len(table.ForeignKeys)-1]
+		foreignKey.Columns = append(foreignKey.Columns, column)
+		// The referenced columns are omitted when the foreign key refers to
+		// the primary key of the other table
+		if referencedColumn.Valid {
+			foreignKey.ReferencedColumns = append(foreignKey.ReferencedColumns, referencedColumn.String)
+		}
+	}
+	return rows.Err()
+}
+
+// sampleQueries selects the first rows of a table, as SQLite does not
+// support sampling without scanning the whole table
+func (s *sqliteLoader) sampleQueries(table Table, rows int) []string {
+	return []string{limitQuery(s.quotedName(table), rows)}
+}
+
+func (s *sqliteLoader) quotedName(table Table) string {
+	return quoteIdentifier(table.Name)
+}
+
+func (s *sqliteLoader) quote(identifier string) string {
+	return quoteIdentifier(identifier)
+}
+
+// fingerprint returns the schema version, which SQLite increments whenever
+// the schema changes
+func (s *sqliteLoader) fingerprint(db *sql.DB) (string, error) {
+	var version int64
+	if err := db.QueryRow("SELECT schema_version FROM pragma_schema_version;").Scan(&version); err != nil {
+		return "", fmt.Errorf("fingerprinting schema: %w", err)
+	}
+	return fmt.Sprint(version), nil
+}
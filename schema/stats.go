@@ -0,0 +1,81 @@
+package schema
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TableStats describes the size and freshness of a table. Values are
+// approximate, taken from statistics maintained by the database, and nil
+// where the database does not provide them.
+type TableStats struct {
+	RowCount     *int64     `json:"row_count,omitempty"`
+	SizeBytes    *int64     `json:"size_bytes,omitempty"`
+	LastModified *time.Time `json:"last_modified,omitempty"`
+}
+
+// newTableStats returns stats from nullable catalog values, or nil if none
+// are known.
+func newTableStats(rowCount, sizeBytes sql.NullInt64, lastModified sql.NullTime) *TableStats {
+	if !rowCount.Valid && !sizeBytes.Valid && !lastModified.Valid {
+		return nil
+	}
+	stats := &TableStats{}
+	if rowCount.Valid {
+		stats.RowCount = &rowCount.Int64
+	}
+	if sizeBytes.Valid {
+		stats.SizeBytes = &sizeBytes.Int64
+	}
+	if lastModified.Valid {
+		stats.LastModified = &lastModified.Time
+	}
+	return stats
+}
+
+// String describes the stats for use in a SQL comment, such as
+// "about 1.2M rows, 340 MB, last modified 2023-05-01 12:00 UTC".
+func (s TableStats) String() string {
+	var parts []string
+	if s.RowCount != nil {
+		parts = append(parts, fmt.Sprintf("about %v rows", formatCount(*s.RowCount)))
+	}
+	if s.SizeBytes != nil {
+		parts = append(parts, formatBytes(*s.SizeBytes))
+	}
+	if s.LastModified != nil {
+		parts = append(parts, "last modified "+s.LastModified.UTC().Format("2006-01-02 15:04 MST"))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatCount abbreviates large numbers, such as 1.2M for 1,200,000
+func formatCount(n int64) string {
+	switch {
+	case n >= 1e9:
+		return fmt.Sprintf("%.1fB", float64(n)/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.1fM", float64(n)/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1fK", float64(n)/1e3)
+	default:
+		return fmt.Sprint(n)
+	}
+}
+
+// formatBytes formats a size in bytes using binary units
+func formatBytes(n int64) string {
+	size := float64(n)
+	for _, unit := range []string{"bytes", "KB", "MB", "GB", "TB"} {
+		if size < 1024 || unit == "TB" {
+			if unit == "bytes" {
+				return fmt.Sprintf("%d bytes", n)
+			}
+			return fmt.Sprintf("%.1f %v", size, unit)
+		}
+		size /= 1024
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+--
+-- PostgreSQL database dump
+--
+
+-- Dumped from database version 15.3
+-- Dumped by pg_dump version 15.3
+
+SET statement_timeout = 0;
+SET lock_timeout = 0;
+SET client_encoding = 'UTF8';
+SET standard_conforming_strings = on;
+SELECT pg_catalog.set_config('search_path', '', false);
+
+--
+-- Name: mood; Type: TYPE; Schema: public; Owner: app
+--
+
+CREATE TYPE public.mood AS ENUM (
+    'sad',
+    'ok',
+    'happy'
+);
+
+
+ALTER TYPE public.mood OWNER TO app;
+
+--
+-- Name: touch_updated_at(); Type: FUNCTION; Schema: public; Owner: app
+--
+
+CREATE FUNCTION public.touch_updated_at() RETURNS trigger
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    -- Statements in the body end with semicolons; they are not statements
+    NEW.updated_at := now();
+    CREATE TABLE not_a_table (id int);
+    RETURN NEW;
+END;
+$$;
+
+
+ALTER FUNCTION public.touch_updated_at() OWNER TO app;
+
+SET default_tablespace = '';
+
+SET default_table_access_method = heap;
+
+--
+-- Name: customers; Type: TABLE; Schema: public; Owner: app
+--
+
+CREATE TABLE public.customers (
+    id integer NOT NULL,
+    "Full Name" character varying(200) NOT NULL,
+    current_mood public.mood DEFAULT 'ok'::public.mood,
+    created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+ALTER TABLE public.customers OWNER TO app;
+
+--
+-- Name: TABLE customers; Type: COMMENT; Schema: public; Owner: app
+--
+
+COMMENT ON TABLE public.customers IS 'People who have placed orders';
+
+
+--
+-- Name: COLUMN customers."Full Name"; Type: COMMENT; Schema: public; Owner: app
+--
+
+COMMENT ON COLUMN public.customers."Full Name" IS 'Name as given at sign up, it''s not verified';
+
+
+--
+-- Name: orders; Type: TABLE; Schema: public; Owner: app
+--
+
+CREATE TABLE public.orders (
+    id bigint NOT NULL,
+    customer_id integer NOT NULL,
+    total numeric(10,2),
+    note text DEFAULT $tag$it's; fine$tag$
+);
+
+
+ALTER TABLE public.orders OWNER TO app;
+
+--
+-- Name: COLUMN orders.note; Type: COMMENT; Schema: public; Owner: app
+--
+
+COMMENT ON COLUMN public.orders.note IS E'Left by the customer, it\'s\nprinted on the receipt';
+
+
+--
+-- Name: order_totals; Type: VIEW; Schema: public; Owner: app
+--
+
+CREATE VIEW public.order_totals AS
+ SELECT orders.customer_id,
+    sum(orders.total) AS total
+   FROM public.orders
+  GROUP BY orders.customer_id;
+
+
+ALTER TABLE public.order_totals OWNER TO app;
+
+--
+-- Name: customers customers_pkey; Type: CONSTRAINT; Schema: public; Owner: app
+--
+
+ALTER TABLE ONLY public.customers
+    ADD CONSTRAINT customers_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: orders orders_pkey; Type: CONSTRAINT; Schema: public; Owner: app
+--
+
+ALTER TABLE ONLY public.orders
+    ADD CONSTRAINT orders_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: orders_customer_id_idx; Type: INDEX; Schema: public; Owner: app
+--
+
+CREATE INDEX orders_customer_id_idx ON public.orders USING btree (customer_id);
+
+
+--
+-- Name: orders orders_customer_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: app
+--
+
+ALTER TABLE ONLY public.orders
+    ADD CONSTRAINT orders_customer_id_fkey FOREIGN KEY (customer_id) REFERENCES public.customers(id);
+
+
+--
+-- PostgreSQL database dump complete
+--
+
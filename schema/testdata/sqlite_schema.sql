@@ -0,0 +1,21 @@
+CREATE TABLE artists (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL -- as credited
+);
+CREATE TABLE sqlite_sequence(name,seq);
+CREATE TABLE [albums] (
+  "id" INTEGER PRIMARY KEY,
+  "artist_id" INTEGER NOT NULL REFERENCES artists,
+  title TEXT,
+  released DATE DEFAULT (date('now'))
+);
+CREATE TABLE tracks (
+  album_id INTEGER,
+  number INTEGER,
+  title TEXT,
+  PRIMARY KEY (album_id, number),
+  FOREIGN KEY (album_id) REFERENCES albums (id) ON DELETE CASCADE
+);
+CREATE INDEX tracks_title ON tracks (title);
+CREATE VIEW album_lengths AS SELECT album_id, count(*) AS tracks FROM tracks GROUP BY album_id
+/* album_lengths(album_id,tracks) */;